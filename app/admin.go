@@ -0,0 +1,325 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	// AdminUploadsPath accepts creator workbooks for preview and publishing:
+	//   POST   AdminUploadsPath?source=NAME      upload, returns an UploadPreview
+	//   POST   AdminUploadsPath/{id}/publish     publish a previewed upload
+	//   DELETE AdminUploadsPath/{id}             discard it
+	AdminUploadsPath = "/api/admin/uploads"
+	// AdminTokenEnvVar holds the bearer token of the admin endpoints
+	AdminTokenEnvVar = "DELTA_TOOL_ADMIN_TOKEN"
+	// maxUploadSize limits the size of an uploaded workbook
+	maxUploadSize = 32 << 20
+	// uploadTTL is how long a preview can be published
+	uploadTTL = time.Hour
+)
+
+// UploadPreview shows what publishing an uploaded workbook would change
+type UploadPreview struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// FileHash is the SHA-256 hash of the uploaded workbook
+	FileHash string `json:"file_hash"`
+	// BaseVersion is the served version the diff is against; publishing
+	// fails if another upload was published in between
+	BaseVersion string `json:"base_version"`
+	// Version is what the codes will be served as once published
+	Version string `json:"version"`
+	// Count is the number of entries parsed from the workbook, TotalCount
+	// the number served after publishing
+	Count      int           `json:"count"`
+	TotalCount int           `json:"total_count"`
+	Added      []WeaponCode  `json:"added"`
+	Changed    []EntryChange `json:"changed"`
+	Removed    []WeaponCode  `json:"removed"`
+	// Problems lists validation failures; such uploads are only published
+	// with ?force=true
+	Problems  []string `json:"problems"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// EntryChange is an entry before and after an upload
+type EntryChange struct {
+	Before WeaponCode `json:"before"`
+	After  WeaponCode `json:"after"`
+}
+
+// PublishResult is the response of a successful publish
+type PublishResult struct {
+	Version    string `json:"version"`
+	TotalCount int    `json:"total_count"`
+}
+
+// adminState holds the admin token and the uploads awaiting publication
+type adminState struct {
+	token string
+
+	mu      sync.Mutex
+	uploads map[string]*pendingUpload
+	// publishMu serialises publishing so the base version check holds
+	publishMu sync.Mutex
+}
+
+// pendingUpload is a parsed upload waiting to be published
+type pendingUpload struct {
+	preview UploadPreview
+	update  sourceUpdate
+	expires time.Time
+}
+
+// LoadAdminToken returns the admin token from tokenFile, or from
+// AdminTokenEnvVar when no file is given. It works like an API key with
+// the admin scope; empty means there is no such token.
+func LoadAdminToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		return strings.TrimSpace(os.Getenv(AdminTokenEnvVar)), nil
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read admin token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+// EnableAdmin turns on the admin endpoints, authenticated by token or by
+// an API key with the admin scope (token may then be empty). Call it
+// before Handler.
+func (s *CodeServer) EnableAdmin(token string) {
+	s.admin = &adminState{
+		token:   token,
+		uploads: make(map[string]*pendingUpload),
+	}
+}
+
+// registerAdmin adds the admin endpoints to mux
+func (s *CodeServer) registerAdmin(mux *http.ServeMux) {
+	mux.Handle("POST "+AdminUploadsPath, s.guard(ScopeAdmin, s.handleUpload))
+	mux.Handle("POST "+AdminUploadsPath+"/{id}/publish", s.guard(ScopeAdmin, s.handlePublish))
+	mux.Handle("DELETE "+AdminUploadsPath+"/{id}", s.guard(ScopeAdmin, s.handleDiscard))
+}
+
+// handleUpload parses an uploaded workbook and returns its diff preview
+// The workbook is the request body, or the "file" field of a multipart form.
+func (s *CodeServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	def, ok := findSourceDefinition(r.URL.Query().Get(SourceParam))
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown or missing %s %q", SourceParam, r.URL.Query().Get(SourceParam)))
+		return
+	}
+
+	data, err := readUploadBody(w, r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	preview, update, err := s.previewUpload(def, data)
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	expires := time.Now().Add(uploadTTL)
+	preview.ExpiresAt = formatCacheTime(expires)
+
+	s.admin.mu.Lock()
+	for id, upload := range s.admin.uploads {
+		if time.Now().After(upload.expires) {
+			delete(s.admin.uploads, id)
+		}
+	}
+	s.admin.uploads[preview.ID] = &pendingUpload{preview: *preview, update: update, expires: expires}
+	s.admin.mu.Unlock()
+
+	fmt.Printf("Upload %s for %s: %d added, %d changed, %d removed\n",
+		preview.ID, def.Name, len(preview.Added), len(preview.Changed), len(preview.Removed))
+	writeJSON(w, http.StatusCreated, preview)
+}
+
+// readUploadBody returns the uploaded workbook, limited to maxUploadSize
+func readUploadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	body := io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the \"file\" form field: %w", err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("upload is empty")
+	}
+	return data, nil
+}
+
+// previewUpload parses a workbook for def and diffs the result against
+// the cache being served
+func (s *CodeServer) previewUpload(def sourceDefinition, data []byte) (*UploadPreview, sourceUpdate, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, sourceUpdate{}, fmt.Errorf("not a readable workbook: %w", err)
+	}
+	defer f.Close()
+
+	codes, err := def.parseWorkbook(f)
+	if err != nil {
+		return nil, sourceUpdate{}, fmt.Errorf("failed to parse %s: %w", def.Name, err)
+	}
+	if len(codes) == 0 {
+		return nil, sourceUpdate{}, fmt.Errorf("no %s weapon codes found in the workbook", def.Name)
+	}
+
+	// The workbook has no path, so hash its bytes and drop the file time
+	meta := excelSourceMeta(def, f, "", time.Now())
+	meta.FileHash = hashBytes(data)
+	update := sourceUpdate{Meta: meta, Codes: codes}
+
+	existing, err := s.cache.readEnvelope()
+	if err != nil {
+		existing = &WeaponCodeCache{}
+	}
+	merged, _ := mergeSourceUpdates(existing, map[string]sourceUpdate{def.Name: update})
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, sourceUpdate{}, fmt.Errorf("failed to create upload ID: %w", err)
+	}
+
+	preview := &UploadPreview{
+		ID:          hex.EncodeToString(id),
+		Source:      def.Name,
+		FileHash:    meta.FileHash,
+		BaseVersion: codeSetVersion(existing.WeaponCodes),
+		Version:     codeSetVersion(merged),
+		Count:       len(codes),
+		TotalCount:  len(merged),
+		Added:       []WeaponCode{},
+		Changed:     []EntryChange{},
+		Removed:     []WeaponCode{},
+		Problems:    validateDataset(existing.WeaponCodes, merged, ValidationConfig{}),
+	}
+	if preview.Problems == nil {
+		preview.Problems = []string{}
+	}
+
+	oldByID := make(map[string]WeaponCode, len(existing.WeaponCodes))
+	for _, code := range existing.WeaponCodes {
+		oldByID[code.ID] = code
+	}
+	delta := DiffCodes(existing.WeaponCodes, merged, preview.BaseVersion)
+	preview.Added = append(preview.Added, delta.Added...)
+	for _, code := range delta.Changed {
+		preview.Changed = append(preview.Changed, EntryChange{Before: oldByID[code.ID], After: code})
+	}
+	for _, id := range delta.Removed {
+		preview.Removed = append(preview.Removed, oldByID[id])
+	}
+	return preview, update, nil
+}
+
+// errUploadNotFound is returned for unknown or expired upload IDs
+var errUploadNotFound = errors.New("upload not found or expired")
+
+// findUpload returns a pending upload
+func (s *CodeServer) findUpload(id string) (*pendingUpload, error) {
+	s.admin.mu.Lock()
+	defer s.admin.mu.Unlock()
+	upload, ok := s.admin.uploads[id]
+	if !ok || time.Now().After(upload.expires) {
+		delete(s.admin.uploads, id)
+		return nil, errUploadNotFound
+	}
+	return upload, nil
+}
+
+// dropUpload forgets a pending upload
+func (s *CodeServer) dropUpload(id string) {
+	s.admin.mu.Lock()
+	defer s.admin.mu.Unlock()
+	delete(s.admin.uploads, id)
+}
+
+// handlePublish saves a previewed upload to the cache and serves it
+// The cache file is replaced atomically and every client gets the new
+// version (or a delta to it) on its next request.
+func (s *CodeServer) handlePublish(w http.ResponseWriter, r *http.Request) {
+	s.admin.publishMu.Lock()
+	defer s.admin.publishMu.Unlock()
+
+	upload, err := s.findUpload(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	preview := upload.preview
+	if len(preview.Problems) > 0 && r.URL.Query().Get("force") != "true" {
+		writeAPIError(w, http.StatusUnprocessableEntity,
+			fmt.Errorf("%w: %s (publish with ?force=true to override)", ErrDatasetRejected, strings.Join(preview.Problems, "; ")))
+		return
+	}
+
+	existing, err := s.cache.readEnvelope()
+	if err != nil {
+		existing = &WeaponCodeCache{}
+	}
+	if version := codeSetVersion(existing.WeaponCodes); version != preview.BaseVersion {
+		s.dropUpload(preview.ID)
+		writeAPIError(w, http.StatusConflict,
+			fmt.Errorf("codes changed since the preview (%s -> %s), upload again", preview.BaseVersion, version))
+		return
+	}
+
+	codes, sources := mergeSourceUpdates(existing, map[string]sourceUpdate{preview.Source: upload.update})
+	if err := s.cache.Save(codes, "admin-upload", sources...); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.dropUpload(preview.ID)
+	if _, err := s.Reload(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	current, _ := s.snapshot()
+	fmt.Printf("Published upload %s for %s as version %s\n", preview.ID, preview.Source, current.version)
+	writeJSON(w, http.StatusOK, PublishResult{Version: current.version, TotalCount: len(current.codes)})
+}
+
+// handleDiscard drops a pending upload
+func (s *CodeServer) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.findUpload(r.PathValue("id")); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	s.dropUpload(r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
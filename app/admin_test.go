@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// adminCall sends an admin request and decodes a successful JSON response
+// into out
+func adminCall(t *testing.T, baseURL, method, path, token string, body []byte, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestAdminUpload uploads workbooks and checks the authentication, the
+// diff preview, the validation gate and that a publish reaches clients
+func TestAdminUpload(t *testing.T) {
+	server, _ := newTestCodeServer(t, testCodes(1))
+	const token = "test-admin-token"
+	server.EnableAdmin(token)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	workbook := testWorkbook(t, [][]string{
+		{"M4A1", "T0", "30w", "满配", testCode(101), "50米", "10.5"},
+		{"", "T1", "15w", "平替", testCode(102), "45米", "10.5"},
+		{"MP7", "T1", "20w", "腰射", testCode(103), "20米", "10.6"},
+	})
+	uploadPath := AdminUploadsPath + "?" + url.Values{SourceParam: {"刀仔"}}.Encode()
+
+	if status := adminCall(t, ts.URL, http.MethodPost, uploadPath, "wrong", workbook, nil); status != http.StatusUnauthorized {
+		t.Fatalf("bad token: status %d", status)
+	}
+
+	var preview UploadPreview
+	if status := adminCall(t, ts.URL, http.MethodPost, uploadPath, token, workbook, &preview); status != http.StatusCreated {
+		t.Fatalf("upload: status %d", status)
+	}
+	// Both old 刀仔 entries go, the three new ones come in, 武器大师 stays
+	if preview.Count != 3 || len(preview.Added) != 3 || len(preview.Removed) != 2 || preview.TotalCount != 4 {
+		t.Fatalf("preview: %d parsed, %d added, %d removed, %d total",
+			preview.Count, len(preview.Added), len(preview.Removed), preview.TotalCount)
+	}
+	if len(preview.Problems) > 0 {
+		t.Fatalf("preview: unexpected problems %v", preview.Problems)
+	}
+
+	// Nothing is served before the publish
+	client := NewAPIClient(ts.URL)
+	before, _, err := client.FetchWeaponCodesConditional(context.Background(), EndpointMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Version != preview.BaseVersion {
+		t.Fatalf("before publish: serving %s, want %s", before.Version, preview.BaseVersion)
+	}
+
+	publishPath := AdminUploadsPath + "/" + preview.ID + "/publish"
+	var result PublishResult
+	if status := adminCall(t, ts.URL, http.MethodPost, publishPath, token, nil, &result); status != http.StatusOK {
+		t.Fatalf("publish: status %d", status)
+	}
+	if result.Version != preview.Version {
+		t.Fatalf("publish: version %s, preview said %s", result.Version, preview.Version)
+	}
+	after, _, err := client.FetchWeaponCodesConditional(context.Background(), EndpointMeta{DataVersion: before.Version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Version != preview.Version || after.Delta == nil {
+		t.Fatalf("after publish: serving %s (delta: %v), want a delta to %s", after.Version, after.Delta != nil, preview.Version)
+	}
+	if status := adminCall(t, ts.URL, http.MethodPost, publishPath, token, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("publish twice: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	// A workbook that would drop most entries needs ?force=true
+	tiny := testWorkbook(t, [][]string{{"M4A1", "T0", "30w", "满配", "NOT-A-CODE", "50米", "10.5"}})
+	if status := adminCall(t, ts.URL, http.MethodPost, uploadPath, token, tiny, &preview); status != http.StatusCreated {
+		t.Fatalf("broken upload: status %d", status)
+	}
+	if len(preview.Problems) == 0 {
+		t.Fatal("broken upload: no problems reported")
+	}
+	publishPath = AdminUploadsPath + "/" + preview.ID + "/publish"
+	if status := adminCall(t, ts.URL, http.MethodPost, publishPath, token, nil, nil); status != http.StatusUnprocessableEntity {
+		t.Fatalf("broken publish: status %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+	if status := adminCall(t, ts.URL, http.MethodDelete, AdminUploadsPath+"/"+preview.ID, token, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("discard: status %d, want %d", status, http.StatusNoContent)
+	}
+}
@@ -1,83 +1,243 @@
 package app
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Endpoints of the weapon code service
+const (
+	// WeaponCodesPath returns an APIResponse with every weapon code
+	WeaponCodesPath = "/api/weapon-codes"
+	// DataPackPath is the directory holding the signed data pack files
+	DataPackPath = "/api/pack/"
+)
+
 // APIClient represents the interface for fetching weapon codes from a remote API
 // This is designed for future integration with a separate data service
 type APIClient struct {
 	baseURL    string
 	httpClient *http.Client
+	retry      RetryPolicy
+	breaker    *CircuitBreaker
+	// apiKey is sent in APIKeyHeader when set
+	apiKey string
 }
 
 // APIResponse represents the response structure from the remote API
 type APIResponse struct {
-	Success    bool         `json:"success"`
-	Version    string       `json:"version"`
-	LastUpdated string      `json:"last_updated"`
-	Data       []WeaponCode `json:"data"`
-	Message    string       `json:"message,omitempty"`
+	Success     bool         `json:"success"`
+	Version     string       `json:"version"`
+	LastUpdated string       `json:"last_updated"`
+	Data        []WeaponCode `json:"data"`
+	Message     string       `json:"message,omitempty"`
+	// Delta is set instead of Data when the request asked for changes
+	// since a version the server still knows
+	Delta *CodeDelta `json:"delta,omitempty"`
+	// TotalCount and NextCursor are set when the request used FetchOptions:
+	// the number of matching entries and the cursor of the next page
+	TotalCount int    `json:"total_count,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-// NewAPIClient creates a new API client
+// NewAPIClient creates a new API client with the default retry policy
+// and circuit breaker
 func NewAPIClient(baseURL string) *APIClient {
+	return NewAPIClientWithPolicy(baseURL, DefaultRetryPolicy, DefaultBreakerConfig)
+}
+
+// NewAPIClientWithPolicy creates a new API client with a custom retry policy
+// and circuit breaker; zero fields take the defaults
+func NewAPIClientWithPolicy(baseURL string, retry RetryPolicy, breaker BreakerConfig) *APIClient {
 	return &APIClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
+			// Per attempt; the caller's context bounds the whole fetch
 			Timeout: 30 * time.Second,
 		},
+		retry:   retry.withDefaults(),
+		breaker: NewCircuitBreaker(breaker),
 	}
 }
 
+// SetAPIKey makes the client authenticate with key (empty = no key)
+func (api *APIClient) SetAPIKey(key string) {
+	api.apiKey = key
+}
+
+// BreakerStatus returns the state of the client's circuit breaker
+func (api *APIClient) BreakerStatus() BreakerStatus {
+	return api.breaker.Status()
+}
+
 // FetchWeaponCodes fetches weapon codes from the remote API
-func (api *APIClient) FetchWeaponCodes() ([]WeaponCode, error) {
-	url := fmt.Sprintf("%s/api/weapon-codes", api.baseURL)
+func (api *APIClient) FetchWeaponCodes(ctx context.Context) ([]WeaponCode, error) {
+	apiResp, err := api.FetchWeaponCodesResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return apiResp.Data, nil
+}
+
+// FetchWeaponCodesResponse fetches the full API response, including its
+// version and last-updated time
+func (api *APIClient) FetchWeaponCodesResponse(ctx context.Context) (*APIResponse, error) {
+	apiResp, _, err := api.FetchWeaponCodesConditional(ctx, EndpointMeta{})
+	return apiResp, err
+}
+
+// FetchWeaponCodesConditional fetches the weapon codes unless they are
+// unchanged since the response prev was recorded from. It returns the
+// endpoint's new validators; an unchanged dataset yields ErrNotModified.
+// When prev has a data version the server may answer with a delta
+// against it (APIResponse.Delta) instead of the full list.
+func (api *APIClient) FetchWeaponCodesConditional(ctx context.Context, prev EndpointMeta) (*APIResponse, EndpointMeta, error) {
+	path := WeaponCodesPath
+	if prev.DataVersion != "" {
+		path += "?" + SinceParam + "=" + url.QueryEscape(prev.DataVersion)
+	}
 
-	resp, err := api.httpClient.Get(url)
+	resp, meta, err := api.getConditional(ctx, path, prev)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch from API: %w", err)
+		return nil, meta, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, meta, fmt.Errorf("API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	// Parse response
 	var apiResp APIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse API response: %w", err)
+		return nil, meta, fmt.Errorf("failed to parse API response: %w", err)
 	}
 
 	if !apiResp.Success {
-		return nil, fmt.Errorf("API error: %s", apiResp.Message)
+		return nil, meta, fmt.Errorf("API error: %s", apiResp.Message)
 	}
 
-	fmt.Printf("Fetched %d weapon codes from API (version: %s)\n",
-		len(apiResp.Data), apiResp.Version)
+	meta.DataVersion = apiResp.Version
+	if apiResp.Delta != nil {
+		fmt.Printf("Fetched delta from API (%s -> %s: %d added, %d changed, %d removed)\n",
+			apiResp.Delta.BaseVersion, apiResp.Version,
+			len(apiResp.Delta.Added), len(apiResp.Delta.Changed), len(apiResp.Delta.Removed))
+	} else {
+		fmt.Printf("Fetched %d weapon codes from API (version: %s)\n",
+			len(apiResp.Data), apiResp.Version)
+	}
 
-	return apiResp.Data, nil
+	return &apiResp, meta, nil
+}
+
+// FetchDataPack downloads the signed data pack published next to the API
+// A missing signature is not an error here; VerifyPack rejects such packs.
+func (api *APIClient) FetchDataPack(ctx context.Context) (*DataPack, error) {
+	pack, _, err := api.FetchDataPackConditional(ctx, EndpointMeta{})
+	return pack, err
+}
+
+// FetchDataPackConditional downloads the signed data pack unless its
+// manifest is unchanged since prev was recorded, in which case it returns
+// ErrNotModified. The returned validators belong to the manifest.
+func (api *APIClient) FetchDataPackConditional(ctx context.Context, prev EndpointMeta) (*DataPack, EndpointMeta, error) {
+	resp, meta, err := api.getConditional(ctx, DataPackPath+PackManifestFile, prev)
+	if err != nil {
+		return nil, meta, err
+	}
+	manifest, err := readPackResponse(resp, PackManifestFile)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	payload, err := api.fetchPackFile(ctx, PackPayloadFile)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	pack := &DataPack{Payload: payload, Manifest: manifest}
+	sigData, err := api.fetchPackFile(ctx, PackSignatureFile)
+	if errors.Is(err, errPackFileNotFound) {
+		return pack, meta, nil
+	}
+	if err != nil {
+		return nil, meta, err
+	}
+
+	if pack.Signature, err = decodeSignature(sigData); err != nil {
+		return nil, meta, err
+	}
+	return pack, meta, nil
+}
+
+// errPackFileNotFound is returned by fetchPackFile on a 404 response
+var errPackFileNotFound = errors.New("data pack file not found")
+
+// fetchPackFile downloads one file of the data pack
+func (api *APIClient) fetchPackFile(ctx context.Context, name string) ([]byte, error) {
+	resp, _, err := api.getConditional(ctx, DataPackPath+name, EndpointMeta{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from API: %w", name, err)
+	}
+	return readPackResponse(resp, name)
+}
+
+// readPackResponse reads the body of a data pack file response and closes it
+func readPackResponse(resp *http.Response, name string) ([]byte, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", name, errPackFileNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d for %s: %s", resp.StatusCode, name, string(body))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
 }
 
 // FetchWeaponCodesWithMode fetches weapon codes filtered by mode
-func (api *APIClient) FetchWeaponCodesWithMode(mode string) ([]WeaponCode, error) {
-	url := fmt.Sprintf("%s/api/weapon-codes?mode=%s", api.baseURL, mode)
+func (api *APIClient) FetchWeaponCodesWithMode(ctx context.Context, mode string) ([]WeaponCode, error) {
+	apiResp, err := api.FetchWeaponCodesWithOptions(ctx, FetchOptions{Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	return apiResp.Data, nil
+}
+
+// FetchWeaponCodesWithOptions fetches the weapon codes matching opts,
+// filtered by the server. When opts.Limit is set the response holds one
+// page; pass its NextCursor as opts.Cursor to fetch the next one.
+func (api *APIClient) FetchWeaponCodesWithOptions(ctx context.Context, opts FetchOptions) (*APIResponse, error) {
+	path := WeaponCodesPath
+	if query := opts.Values().Encode(); query != "" {
+		path += "?" + query
+	}
 
-	resp, err := api.httpClient.Get(url)
+	resp, _, err := api.getConditional(ctx, path, EndpointMeta{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch from API: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	var apiResp APIResponse
@@ -89,7 +249,7 @@ func (api *APIClient) FetchWeaponCodesWithMode(mode string) ([]WeaponCode, error
 		return nil, fmt.Errorf("API error: %s", apiResp.Message)
 	}
 
-	return apiResp.Data, nil
+	return &apiResp, nil
 }
 
 // DataSourceConfig represents the configuration for data sources
@@ -99,22 +259,43 @@ type DataSourceConfig struct {
 	// LocalCachePath specifies the path to local cache (empty for default)
 	LocalCachePath string
 	// APIBaseURL specifies the base URL for remote API (empty to disable)
+	// It is tried as a mirror of priority 0, next to Mirrors.
 	APIBaseURL string
+	// APIKey is sent to APIBaseURL; Mirrors carry their own keys
+	APIKey string
+	// Mirrors lists further API base URLs, tried in priority order
+	Mirrors []Mirror
 	// CacheMaxAge specifies how long the local cache is valid (0 = forever)
 	CacheMaxAge time.Duration
+	// TrustedKeys enables signed data packs: when set, codes are only accepted
+	// from a pack signed by one of these keys (see LoadTrustedKeys)
+	TrustedKeys []ed25519.PublicKey
+	// Retry controls retries of failed API requests (zero = DefaultRetryPolicy)
+	Retry RetryPolicy
+	// Breaker controls the API circuit breaker (zero = DefaultBreakerConfig)
+	Breaker BreakerConfig
+	// Validation controls the checks fetched codes must pass before they
+	// replace the cache
+	Validation ValidationConfig
+	// QuarantinePath is where rejected datasets are written (empty = not kept)
+	QuarantinePath string
 }
 
 // WeaponCodeLoader handles loading weapon codes from various sources
 type WeaponCodeLoader struct {
 	cacheManager *CacheManager
-	apiClient    *APIClient
+	mirrors      []*mirrorClient
 	config       DataSourceConfig
+
+	// preferred is the URL of the mirror that last succeeded
+	preferred   string
+	preferredMu sync.Mutex
 }
 
 // NewWeaponCodeLoader creates a new weapon code loader with default config
-func NewWeaponCodeLoader() *WeaponCodeLoader {
+func NewWeaponCodeLoader(cacheManager *CacheManager) *WeaponCodeLoader {
 	return &WeaponCodeLoader{
-		cacheManager: NewCacheManager(),
+		cacheManager: cacheManager,
 		config: DataSourceConfig{
 			UseLocalCache: true,
 			CacheMaxAge:   24 * time.Hour, // Default: cache valid for 24 hours
@@ -123,15 +304,17 @@ func NewWeaponCodeLoader() *WeaponCodeLoader {
 }
 
 // NewWeaponCodeLoaderWithConfig creates a new weapon code loader with custom config
-func NewWeaponCodeLoaderWithConfig(config DataSourceConfig) *WeaponCodeLoader {
+func NewWeaponCodeLoaderWithConfig(cacheManager *CacheManager, config DataSourceConfig) *WeaponCodeLoader {
 	loader := &WeaponCodeLoader{
-		cacheManager: NewCacheManager(),
+		cacheManager: cacheManager,
 		config:       config,
 	}
 
+	mirrors := config.Mirrors
 	if config.APIBaseURL != "" {
-		loader.apiClient = NewAPIClient(config.APIBaseURL)
+		mirrors = append([]Mirror{{URL: config.APIBaseURL, APIKey: config.APIKey}}, mirrors...)
 	}
+	loader.mirrors = newMirrorClients(mirrors, config.Retry, config.Breaker)
 
 	return loader
 }
@@ -141,9 +324,10 @@ func NewWeaponCodeLoaderWithConfig(config DataSourceConfig) *WeaponCodeLoader {
 // 1. API (if configured and cache is expired)
 // 2. Local cache (if enabled and valid)
 // 3. Returns error if no source is available
-func (loader *WeaponCodeLoader) Load() ([]WeaponCode, error) {
+// The context bounds any API request, including its retries.
+func (loader *WeaponCodeLoader) Load(ctx context.Context) ([]WeaponCode, error) {
 	// Try API first if configured and cache should be refreshed
-	if loader.apiClient != nil {
+	if len(loader.mirrors) > 0 {
 		shouldRefresh := true
 		if loader.config.UseLocalCache && loader.config.CacheMaxAge > 0 {
 			expired, err := loader.cacheManager.IsCacheExpired(loader.config.CacheMaxAge)
@@ -154,12 +338,8 @@ func (loader *WeaponCodeLoader) Load() ([]WeaponCode, error) {
 
 		if shouldRefresh {
 			fmt.Println("Fetching weapon codes from API...")
-			codes, err := loader.apiClient.FetchWeaponCodes()
+			codes, _, err := loader.fetchAndStore(ctx)
 			if err == nil {
-				// Update cache with fresh data
-				if loader.config.UseLocalCache {
-					go loader.cacheManager.Save(codes, "api")
-				}
 				return codes, nil
 			}
 			fmt.Printf("API fetch failed: %v, falling back to cache\n", err)
@@ -179,3 +359,295 @@ func (loader *WeaponCodeLoader) Load() ([]WeaponCode, error) {
 
 	return nil, fmt.Errorf("no weapon codes available (API not configured or failed, cache not available)")
 }
+
+// Cached returns the codes in the local cache without contacting the API
+// The GUI reads through it and leaves fetching to the background refresh,
+// so an unreachable API never blocks the window. It is called on every
+// request of the bindings, so it reads quietly; Load and Refresh log.
+func (loader *WeaponCodeLoader) Cached() ([]WeaponCode, error) {
+	cache, err := loader.cacheManager.readEnvelope()
+	if err != nil {
+		return nil, fmt.Errorf("no weapon codes in the cache: %w", err)
+	}
+	return cache.WeaponCodes, nil
+}
+
+// Refresh fetches fresh weapon codes from the API regardless of the cache age
+// and stores them in the cache before returning. The boolean is false when
+// the API reported the cached codes as unchanged.
+func (loader *WeaponCodeLoader) Refresh(ctx context.Context) ([]WeaponCode, bool, error) {
+	if len(loader.mirrors) == 0 {
+		return nil, false, fmt.Errorf("API not configured")
+	}
+	return loader.fetchAndStore(ctx)
+}
+
+// MirrorStatus returns the health of every API mirror, in the order they
+// are tried; it is empty when no API is configured
+func (loader *WeaponCodeLoader) MirrorStatus() []MirrorStatus {
+	preferred := loader.preferredMirror()
+	var statuses []MirrorStatus
+	for _, m := range loader.orderedMirrors() {
+		statuses = append(statuses, m.status(m.URL == preferred))
+	}
+	return statuses
+}
+
+// RefreshInterval returns how often the codes should be refreshed in the
+// background, or 0 when there is nothing to refresh from.
+func (loader *WeaponCodeLoader) RefreshInterval() time.Duration {
+	if len(loader.mirrors) == 0 {
+		return 0
+	}
+	return loader.config.CacheMaxAge
+}
+
+// fetchAndStore fetches the codes from the API with a conditional request
+// and updates the cache. On 304 Not Modified only the check time is
+// recorded and the cached codes are returned with changed set to false.
+func (loader *WeaponCodeLoader) fetchAndStore(ctx context.Context) ([]WeaponCode, bool, error) {
+	endpoint := loader.endpoint()
+
+	var prev EndpointMeta
+	if loader.config.UseLocalCache {
+		prev = loader.cacheManager.EndpointValidators(endpoint)
+	}
+
+	apiResp, meta, err := loader.fetchFromMirrors(ctx, prev)
+	if errors.Is(err, ErrNotModified) {
+		if err := loader.cacheManager.MarkChecked(endpoint, meta); err == nil {
+			if codes, found, err := loader.cacheManager.Load(); err == nil && found {
+				fmt.Println("Weapon codes not modified since last fetch")
+				return codes, false, nil
+			}
+		}
+		// The cache went missing behind our back, fetch everything again
+		apiResp, meta, err = loader.fetchFromMirrors(ctx, EndpointMeta{})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Update cache with fresh data
+	if loader.config.UseLocalCache {
+		sources := apiSourceMeta(apiResp, time.Now())
+		if err := loader.cacheManager.SaveFetched(endpoint, meta, apiResp.Data, "api", sources...); err != nil {
+			return nil, false, fmt.Errorf("failed to save fetched codes: %w", err)
+		}
+	}
+	return apiResp.Data, true, nil
+}
+
+// applyDelta replaces the delta in apiResp with the full list of codes,
+// built by applying it to the cached codes at baseVersion
+func (loader *WeaponCodeLoader) applyDelta(apiResp *APIResponse, baseVersion string) error {
+	if apiResp.Delta == nil {
+		return nil
+	}
+
+	cached, err := loader.cacheManager.readEnvelope()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDeltaChainBroken, err)
+	}
+
+	codes, err := ApplyDelta(cached.WeaponCodes, baseVersion, apiResp.Delta)
+	if err != nil {
+		return err
+	}
+	apiResp.Data = codes
+	apiResp.Delta = nil
+	return nil
+}
+
+// endpoint returns the API endpoint whose validators decide if codes changed
+func (loader *WeaponCodeLoader) endpoint() string {
+	if len(loader.config.TrustedKeys) > 0 {
+		return DataPackPath + PackManifestFile
+	}
+	return WeaponCodesPath
+}
+
+// fetchFromMirrors fetches weapon codes from the first mirror that can
+// serve them, in the order of orderedMirrors.
+// The returned validators record which mirror answered.
+func (loader *WeaponCodeLoader) fetchFromMirrors(ctx context.Context, prev EndpointMeta) (*APIResponse, EndpointMeta, error) {
+	var errs []error
+	for _, m := range loader.orderedMirrors() {
+		apiResp, meta, err := loader.fetchFromMirror(ctx, m, prev)
+		if err == nil || errors.Is(err, ErrNotModified) {
+			m.recordSuccess()
+			loader.setPreferredMirror(m.URL)
+			meta.Mirror = m.URL
+			return apiResp, meta, err
+		}
+		if ctx.Err() != nil {
+			return nil, EndpointMeta{}, ctx.Err()
+		}
+
+		m.recordFailure(err)
+		errs = append(errs, fmt.Errorf("%s: %w", m.URL, err))
+		if len(loader.mirrors) > 1 {
+			fmt.Printf("Mirror %s failed: %v\n", m.URL, err)
+		}
+	}
+	return nil, EndpointMeta{}, errors.Join(errs...)
+}
+
+// fetchFromMirror fetches the complete, validated list of codes from one
+// mirror. A delta is applied to the cached codes, falling back to a full
+// fetch from the same mirror if the chain is broken.
+func (loader *WeaponCodeLoader) fetchFromMirror(ctx context.Context, m *mirrorClient, prev EndpointMeta) (*APIResponse, EndpointMeta, error) {
+	apiResp, meta, err := loader.fetchFromAPI(ctx, m.client, prev)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	if apiResp.Delta != nil {
+		if err := loader.applyDelta(apiResp, prev.DataVersion); err != nil {
+			fmt.Printf("Could not apply delta (%v), fetching all weapon codes\n", err)
+			if apiResp, meta, err = loader.fetchFromAPI(ctx, m.client, EndpointMeta{}); err != nil {
+				return nil, meta, err
+			}
+		}
+	}
+
+	if err := loader.validate(apiResp, m.URL); err != nil {
+		return nil, meta, err
+	}
+	return apiResp, meta, nil
+}
+
+// validate runs the validation gate on fetched codes before they may
+// replace the cache. A rejected dataset is written to the quarantine file.
+func (loader *WeaponCodeLoader) validate(apiResp *APIResponse, mirror string) error {
+	var current []WeaponCode
+	if cached, err := loader.cacheManager.readEnvelope(); err == nil {
+		current = cached.WeaponCodes
+	}
+
+	problems := validateDataset(current, apiResp.Data, loader.config.Validation)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Rejected %d weapon codes from %s:\n", len(apiResp.Data), mirror)
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+	if path := loader.config.QuarantinePath; path != "" {
+		if err := quarantineDataset(path, mirror, problems, apiResp); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		} else {
+			fmt.Printf("Rejected dataset saved to %s\n", path)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDatasetRejected, strings.Join(problems, "; "))
+}
+
+// orderedMirrors returns the mirrors in the order they should be tried:
+// by priority, with mirrors that are still cooling down after a failure
+// moved to the end, so a recovered primary is asked first again
+func (loader *WeaponCodeLoader) orderedMirrors() []*mirrorClient {
+	cooldown := loader.config.Breaker.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerConfig.Cooldown
+	}
+	now := time.Now()
+
+	ordered := make([]*mirrorClient, 0, len(loader.mirrors))
+	var coolingDown []*mirrorClient
+	for _, m := range loader.mirrors {
+		if m.coolingDown(cooldown, now) {
+			coolingDown = append(coolingDown, m)
+		} else {
+			ordered = append(ordered, m)
+		}
+	}
+	return append(ordered, coolingDown...)
+}
+
+// preferredMirror returns the mirror that last succeeded, as shown in
+// MirrorStatus; before the first fetch it is the one recorded in the cache
+func (loader *WeaponCodeLoader) preferredMirror() string {
+	loader.preferredMu.Lock()
+	defer loader.preferredMu.Unlock()
+	if loader.preferred == "" && loader.config.UseLocalCache {
+		loader.preferred = loader.cacheManager.EndpointValidators(loader.endpoint()).Mirror
+	}
+	return loader.preferred
+}
+
+// setPreferredMirror remembers the mirror that last succeeded
+func (loader *WeaponCodeLoader) setPreferredMirror(url string) {
+	loader.preferredMu.Lock()
+	defer loader.preferredMu.Unlock()
+	loader.preferred = url
+}
+
+// fetchFromAPI fetches weapon codes from one mirror
+// With trusted keys configured, only a correctly signed data pack is accepted.
+func (loader *WeaponCodeLoader) fetchFromAPI(ctx context.Context, client *APIClient, prev EndpointMeta) (*APIResponse, EndpointMeta, error) {
+	if len(loader.config.TrustedKeys) == 0 {
+		return client.FetchWeaponCodesConditional(ctx, prev)
+	}
+
+	pack, meta, err := client.FetchDataPackConditional(ctx, prev)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	manifest, err := VerifyPack(pack, loader.config.TrustedKeys)
+	if err != nil {
+		return nil, meta, fmt.Errorf("rejected data pack: %w", err)
+	}
+	if err := loader.checkPackReplay(manifest); err != nil {
+		return nil, meta, fmt.Errorf("rejected data pack: %w", err)
+	}
+	meta.PackCreatedAt = manifest.CreatedAt
+
+	apiResp, err := DecodePackPayload(pack)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	fmt.Printf("Fetched %d weapon codes from signed data pack (version: %s, key: %s)\n",
+		len(apiResp.Data), manifest.Version, manifest.KeyID)
+
+	return apiResp, meta, nil
+}
+
+// checkPackReplay rejects a verified pack created before the pack the
+// cache came from, so an old signed pack cannot roll the data back
+func (loader *WeaponCodeLoader) checkPackReplay(manifest *PackManifest) error {
+	if !loader.config.UseLocalCache {
+		return nil
+	}
+	last := loader.cacheManager.PackHighWater()
+	cached, err := parseCacheTime(last)
+	if err != nil {
+		return nil // No pack cached yet
+	}
+	created, err := parseCacheTime(manifest.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("invalid pack creation time %q: %w", manifest.CreatedAt, err)
+	}
+	if created.Before(cached) {
+		return fmt.Errorf("%w: created %s, cached pack created %s", ErrPackReplayed, manifest.CreatedAt, last)
+	}
+	return nil
+}
+
+// apiSourceMeta returns the cache metadata for codes fetched from the API
+// The API's last-updated time is recorded as each source's data-as-of time.
+func apiSourceMeta(apiResp *APIResponse, fetchedAt time.Time) []SourceMeta {
+	dataAsOf := ""
+	if t, err := parseCacheTime(apiResp.LastUpdated); err == nil {
+		dataAsOf = formatCacheTime(t)
+	}
+
+	sources := buildSourceMeta(apiResp.Data, nil, fetchedAt)
+	for i := range sources {
+		sources[i].DataAsOf = dataAsOf
+	}
+	return sources
+}
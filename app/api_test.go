@@ -0,0 +1,177 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// TestValidationGate serves broken datasets and checks that they are
+// quarantined while the cache keeps the good codes
+func TestValidationGate(t *testing.T) {
+	good := apitest.Codes(1)
+	server := apitest.NewServer(t, good)
+	quarantine := filepath.Join(t.TempDir(), app.QuarantineFileName)
+	loader, cm := newLoader(t, server.URL, app.DataSourceConfig{
+		Retry:          app.RetryPolicy{MaxAttempts: 1},
+		Validation:     app.ValidationConfig{MaxDropPercent: 50},
+		QuarantinePath: quarantine,
+	})
+	ctx := context.Background()
+
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("good dataset: %v", err)
+	}
+
+	badCode := append([]app.WeaponCode{}, good...)
+	badCode[0].Code = "not a code"
+	badMode := append([]app.WeaponCode{}, good...)
+	badMode[1].Mode = "unknown"
+
+	rejected := []struct {
+		name  string
+		codes []app.WeaponCode
+	}{
+		{"empty", []app.WeaponCode{}},
+		{"invalid code", badCode},
+		{"invalid mode", badMode},
+		{"vanished source", []app.WeaponCode{good[0], good[2]}},
+		{"too many removed", good[:1]},
+	}
+	for _, test := range rejected {
+		os.Remove(quarantine)
+		server.SetCodes(test.codes)
+
+		if _, _, err := loader.Refresh(ctx); !errors.Is(err, app.ErrDatasetRejected) {
+			t.Fatalf("%s: got %v, want %v", test.name, err, app.ErrDatasetRejected)
+		}
+		cache, err := cm.LoadEnvelope()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !sameCodes(cache.WeaponCodes, good) {
+			t.Fatalf("%s: the rejected dataset replaced the cache", test.name)
+		}
+		if _, err := os.Stat(quarantine); err != nil {
+			t.Fatalf("%s: rejected dataset not quarantined: %v", test.name, err)
+		}
+	}
+
+	// Dropping one entry out of three stays within the 50% limit
+	server.SetCodes(good[1:])
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("allowed drop: %v", err)
+	}
+}
+
+// TestValidationGateDuplicateIDs serves duplicate IDs straight from a
+// handler, so nothing on the way can repair them
+func TestValidationGateDuplicateIDs(t *testing.T) {
+	codes := apitest.Codes(1)
+	codes[2].ID = codes[0].ID
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(app.APIResponse{Success: true, Version: "duplicate", Data: codes})
+	}))
+	defer server.Close()
+
+	loader, _ := newLoader(t, server.URL, app.DataSourceConfig{
+		Retry:          app.RetryPolicy{MaxAttempts: 1},
+		QuarantinePath: filepath.Join(t.TempDir(), app.QuarantineFileName),
+	})
+	if _, _, err := loader.Refresh(context.Background()); !errors.Is(err, app.ErrDatasetRejected) {
+		t.Fatalf("got %v, want %v", err, app.ErrDatasetRejected)
+	}
+}
+
+// TestFetchWithOptions asks a server for filtered pages and checks the
+// query encoding, each filter, the page walk and the rejection of bad
+// parameters
+func TestFetchWithOptions(t *testing.T) {
+	price := func(n int) *int { return &n }
+	updated := func(s string) *string { return &s }
+	codes := []app.WeaponCode{
+		{ID: "1", Mode: "烽火地带", Name: "M4A1", Tier: "T0", Price: price(30), UpdateTime: updated("2025-03-01"), Code: apitest.Code(1), Source: "刀仔"},
+		{ID: "2", Mode: "烽火地带", Name: "MP7", Tier: "T1", Price: price(12), UpdateTime: updated("2025-01-10"), Code: apitest.Code(2), Source: "刀仔"},
+		{ID: "3", Mode: "全面战场", Name: "AK-47", Tier: "T1", Price: price(45), Code: apitest.Code(3), Source: "武器大师"},
+		{ID: "4", Mode: "烽火地带", Name: "M14 射手步枪", Tier: "T2", Code: apitest.Code(4), Source: "武器大师"},
+		{ID: "5", Mode: "全面战场", Name: "Vector", Tier: "T0", Price: price(20), UpdateTime: updated("2025-02-15"), Code: apitest.Code(5), Source: "武器大师"},
+	}
+	server := apitest.NewServer(t, codes)
+	client := app.NewAPIClientWithPolicy(server.URL, app.RetryPolicy{MaxAttempts: 1}, app.BreakerConfig{})
+	ctx := context.Background()
+
+	since, _ := time.Parse(time.DateOnly, "2025-02-01")
+	checks := []struct {
+		step string
+		opts app.FetchOptions
+		want []string
+	}{
+		{"mode", app.FetchOptions{Mode: "全面战场"}, []string{"AK-47", "Vector"}},
+		{"source", app.FetchOptions{Source: "刀仔"}, []string{"M4A1", "MP7"}},
+		{"weapon", app.FetchOptions{Weapon: "m4"}, []string{"M4A1"}},
+		{"class", app.FetchOptions{Class: "冲锋枪"}, []string{"MP7", "Vector"}},
+		{"tier", app.FetchOptions{Tier: "T0", Mode: "烽火地带"}, []string{"M4A1"}},
+		{"price range", app.FetchOptions{MinPrice: price(15), MaxPrice: price(40)}, []string{"M4A1", "Vector"}},
+		{"updated since", app.FetchOptions{UpdatedSince: since}, []string{"M4A1", "Vector"}},
+	}
+	for _, check := range checks {
+		resp, err := client.FetchWeaponCodesWithOptions(ctx, check.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", check.step, err)
+		}
+		var got []string
+		for _, code := range resp.Data {
+			got = append(got, code.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(check.want) {
+			t.Errorf("%s: got %v, want %v", check.step, got, check.want)
+		}
+	}
+
+	// Walk every page, two entries at a time
+	var walked []string
+	opts := app.FetchOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > len(codes) {
+			t.Fatal("pagination: cursor never ran out")
+		}
+		resp, err := client.FetchWeaponCodesWithOptions(ctx, opts)
+		if err != nil {
+			t.Fatalf("pagination: %v", err)
+		}
+		if resp.TotalCount != len(codes) {
+			t.Fatalf("pagination: total count %d, want %d", resp.TotalCount, len(codes))
+		}
+		for _, code := range resp.Data {
+			walked = append(walked, code.Name)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		opts.Cursor = resp.NextCursor
+	}
+	if len(walked) != len(codes) {
+		t.Fatalf("pagination: walked %d entries, want %d", len(walked), len(codes))
+	}
+
+	// Bad parameters are refused rather than ignored
+	for _, bad := range []app.FetchOptions{
+		{MinPrice: price(50), MaxPrice: price(10)},
+		{Limit: app.MaxPageLimit + 1},
+		{Cursor: "!"},
+	} {
+		if _, err := client.FetchWeaponCodesWithOptions(ctx, bad); err == nil {
+			t.Errorf("bad parameters %s: no error", bad.Values().Encode())
+		}
+	}
+}
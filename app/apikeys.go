@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// APIKeysFileName lists the keys accepted by the serve command, in the
+	// config directory: one "NAME KEY SCOPE[,SCOPE]" per line
+	APIKeysFileName = "api_keys"
+	// APIKeyHeader carries the API key of a request; "Authorization: Bearer"
+	// is accepted as well
+	APIKeyHeader = "X-API-Key"
+	// APIKeyEnvVar is the key the client sends to the mirrors listed in
+	// APIURLEnvVar
+	APIKeyEnvVar = "DELTA_TOOL_API_KEY"
+)
+
+// API key scopes
+const (
+	// ScopeRead allows fetching weapon codes
+	ScopeRead = "read"
+	// ScopeAdmin allows the admin endpoints, and implies ScopeRead
+	ScopeAdmin = "admin"
+)
+
+// APIKey is one key accepted by the serve command
+type APIKey struct {
+	// Name identifies the key in logs and rate limits; the key itself is
+	// never printed
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// HasScope reports whether the key grants scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAPIKeys reads an API keys file
+// A missing file yields no keys, which leaves the server open.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var keys []APIKey
+	names := make(map[string]bool)
+	secrets := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s line %d: want \"NAME KEY SCOPE[,SCOPE]\"", path, lineNum)
+		}
+		key := APIKey{Name: fields[0], Key: fields[1]}
+		for _, scope := range strings.Split(fields[2], ",") {
+			if scope != ScopeRead && scope != ScopeAdmin {
+				return nil, fmt.Errorf("%s line %d: unknown scope %q", path, lineNum, scope)
+			}
+			key.Scopes = append(key.Scopes, scope)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("%s line %d: duplicate key name %q", path, lineNum, key.Name)
+		}
+		if secrets[key.Key] {
+			return nil, fmt.Errorf("%s line %d: key of %q is already in use", path, lineNum, key.Name)
+		}
+		names[key.Name] = true
+		secrets[key.Key] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// adminTokenKeyName is how the admin token shows up in logs and rate limits
+const adminTokenKeyName = "admin-token"
+
+// EnableAPIKeys requires one of keys for fetching weapon codes
+// Keys with the admin scope may also use the admin endpoints.
+func (s *CodeServer) EnableAPIKeys(keys []APIKey) {
+	s.keys = keys
+}
+
+// EnableRateLimit limits how fast each API key, or each IP address for
+// requests without a key, may send requests
+func (s *CodeServer) EnableRateLimit(limit RateLimit) {
+	s.limiter = newRateLimiter(limit)
+}
+
+// requestKey returns the key a request authenticates with
+// provided is false when the request carries no key at all.
+func (s *CodeServer) requestKey(r *http.Request) (key APIKey, provided, valid bool) {
+	secret := r.Header.Get(APIKeyHeader)
+	if secret == "" {
+		secret, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if secret == "" {
+		return APIKey{}, false, false
+	}
+
+	candidates := s.keys
+	if s.admin != nil && s.admin.token != "" {
+		candidates = append(candidates[:len(candidates):len(candidates)],
+			APIKey{Name: adminTokenKeyName, Key: s.admin.token, Scopes: []string{ScopeAdmin}})
+	}
+	for _, candidate := range candidates {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(candidate.Key)) == 1 {
+			return candidate, true, true
+		}
+	}
+	return APIKey{}, true, false
+}
+
+// guard rate limits a handler and requires a key with scope for it
+// ScopeRead is only enforced once API keys are enabled; an empty scope
+// needs no key at all.
+func (s *CodeServer) guard(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, provided, valid := s.requestKey(r)
+		if info := requestInfoFrom(r); info != nil && valid {
+			info.key = key.Name
+		}
+
+		if s.limiter != nil {
+			// A key is limited on its own and together with the address
+			// it is used from, so neither sharing a key nor rotating keys
+			// gets around the limit
+			clients := []string{"ip:" + clientIP(r)}
+			if valid {
+				clients = append(clients, "key:"+key.Name)
+			}
+			if ok, wait := s.limiter.allow(time.Now(), clients...); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeAPIError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded, retry in %s", wait.Round(time.Millisecond)))
+				return
+			}
+		}
+
+		needKey := scope == ScopeAdmin || (scope == ScopeRead && s.keys != nil)
+		switch {
+		case !needKey:
+		case !valid:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="delta-tool"`)
+			message := "missing API key"
+			if provided {
+				message = "invalid API key"
+			}
+			writeAPIError(w, http.StatusUnauthorized, errors.New(message))
+			return
+		case !key.HasScope(scope):
+			writeAPIError(w, http.StatusForbidden, fmt.Errorf("API key %s lacks the %s scope", key.Name, scope))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// clientIP returns the IP address a request came from
+// Proxy headers are ignored: they are trivially forged.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKeysFile writes an API keys file and loads it
+func writeKeysFile(t *testing.T, content string) []APIKey {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), APIKeysFileName)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+	return keys
+}
+
+// requestStatus sends a request with an API key and returns the status
+func requestStatus(t *testing.T, method, url, key string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set(APIKeyHeader, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestAuthAndRateLimit serves codes behind API keys and a rate limit and
+// checks the 401, 403 and 429 answers and how the client copes with them
+func TestAuthAndRateLimit(t *testing.T) {
+	keys := writeKeysFile(t, "# name key scopes\nreader test-read-key read\nops test-admin-key admin\n")
+	server, _ := newTestCodeServer(t, testCodes(1))
+	server.EnableAPIKeys(keys)
+	server.EnableAdmin("")
+	server.EnableRateLimit(RateLimit{Rate: 2, Burst: 8})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	ctx := context.Background()
+
+	// fetch asks for the codes once with key
+	fetch := func(key string) error {
+		client := NewAPIClientWithPolicy(ts.URL, RetryPolicy{MaxAttempts: 1}, BreakerConfig{})
+		client.SetAPIKey(key)
+		_, _, err := client.FetchWeaponCodesConditional(ctx, EndpointMeta{})
+		return err
+	}
+
+	if err := fetch(""); err == nil {
+		t.Fatal("no key: codes were served")
+	}
+	if err := fetch("wrong"); err == nil {
+		t.Fatal("wrong key: codes were served")
+	}
+	if err := fetch("test-read-key"); err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	if status := requestStatus(t, http.MethodGet, ts.URL+HealthPath, ""); status != http.StatusOK {
+		t.Fatalf("health without key: status %d", status)
+	}
+	if status := requestStatus(t, http.MethodDelete, ts.URL+AdminUploadsPath+"/none", "test-read-key"); status != http.StatusForbidden {
+		t.Fatalf("read key on admin endpoint: status %d, want %d", status, http.StatusForbidden)
+	}
+	if status := requestStatus(t, http.MethodDelete, ts.URL+AdminUploadsPath+"/none", "test-admin-key"); status != http.StatusNotFound {
+		t.Fatalf("admin key on admin endpoint: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Drain the key's bucket and expect 429
+	limited := false
+	for i := 0; i < 10 && !limited; i++ {
+		limited = requestStatus(t, http.MethodGet, ts.URL+WeaponCodesPath, "test-admin-key") == http.StatusTooManyRequests
+	}
+	if !limited {
+		t.Fatal("rate limit: 10 quick requests were all served")
+	}
+
+	// A client without retries gives up, but the breaker stays closed
+	client := NewAPIClientWithPolicy(ts.URL, RetryPolicy{MaxAttempts: 1}, BreakerConfig{Threshold: 1})
+	client.SetAPIKey("test-admin-key")
+	if _, _, err := client.FetchWeaponCodesConditional(ctx, EndpointMeta{}); err == nil {
+		t.Fatal("rate limit: drained bucket still served")
+	}
+	if state := client.BreakerStatus().State; state != BreakerClosed {
+		t.Fatalf("rate limit: breaker is %s after a 429, want %s", state, BreakerClosed)
+	}
+
+	// A retrying client waits for Retry-After and gets through
+	retrying := NewAPIClientWithPolicy(ts.URL, RetryPolicy{MaxAttempts: 3}, BreakerConfig{})
+	retrying.SetAPIKey("test-admin-key")
+	if _, _, err := retrying.FetchWeaponCodesConditional(ctx, EndpointMeta{}); err != nil {
+		t.Fatalf("retry after 429: %v", err)
+	}
+}
+
+// TestRateLimitPerKeyAndIP checks that a request is charged to both its key
+// and its address, so a shared key and rotated keys both hit a limit
+func TestRateLimitPerKeyAndIP(t *testing.T) {
+	keys := writeKeysFile(t, "# name key scopes\nshared shared-key read\nfirst first-key read\nsecond second-key read\n")
+	server, _ := newTestCodeServer(t, testCodes(1))
+	server.EnableAPIKeys(keys)
+	server.EnableRateLimit(RateLimit{Rate: 0.001, Burst: 2})
+	handler := server.Handler()
+
+	// status sends a request with key from addr
+	status := func(key, addr string) int {
+		req := httptest.NewRequest(http.MethodGet, WeaponCodesPath, nil)
+		req.Header.Set(APIKeyHeader, key)
+		req.RemoteAddr = addr + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// One key used from many addresses shares one budget
+	for i, addr := range []string{"10.0.0.1", "10.0.0.2"} {
+		if code := status("shared-key", addr); code != http.StatusOK {
+			t.Fatalf("shared key request %d: status %d", i, code)
+		}
+	}
+	if code := status("shared-key", "10.0.0.3"); code != http.StatusTooManyRequests {
+		t.Errorf("shared key from a new address: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// One address rotating keys shares one budget
+	for i, key := range []string{"first-key", "second-key"} {
+		if code := status(key, "10.0.0.9"); code != http.StatusOK {
+			t.Fatalf("rotated key request %d: status %d", i, code)
+		}
+	}
+	if code := status("first-key", "10.0.0.9"); code != http.StatusTooManyRequests {
+		t.Errorf("rotated key: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// A rejected request is not charged: the second key still has a token
+	if code := status("second-key", "10.0.0.10"); code != http.StatusOK {
+		t.Errorf("key after a rejected request: status %d", code)
+	}
+}
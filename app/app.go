@@ -3,31 +3,40 @@ package app
 import (
 	"context"
 	"fmt"
+	"sync"
 )
 
 // App struct
 type App struct {
 	ctx          context.Context
+	data         *DataLayer
 	codeLoader   *WeaponCodeLoader
 	cacheManager *CacheManager
 	enableExcel  bool // Set to true only in development mode
+	// lifetime is cancelled on shutdown to abort refreshes and API requests
+	lifetime context.Context
+	cancel   context.CancelFunc
+	// refreshMu keeps scheduled and event-driven refreshes from overlapping
+	refreshMu sync.Mutex
 }
 
-// NewApp creates a new App application struct
-func NewApp() *App {
+// NewApp creates a new App application struct backed by the shared data layer
+func NewApp(data *DataLayer) *App {
 	return &App{
-		codeLoader:   NewWeaponCodeLoader(),
-		cacheManager: NewCacheManager(),
+		data:         data,
+		codeLoader:   data.Loader,
+		cacheManager: data.Cache,
 		enableExcel:  false, // Default to false for production builds
 	}
 }
 
 // NewAppWithExcel creates a new App with Excel support enabled
 // Use this only in development mode for generating cache
-func NewAppWithExcel() *App {
+func NewAppWithExcel(data *DataLayer) *App {
 	return &App{
-		codeLoader:   NewWeaponCodeLoader(),
-		cacheManager: NewCacheManager(),
+		data:         data,
+		codeLoader:   data.Loader,
+		cacheManager: data.Cache,
 		enableExcel:  true,
 	}
 }
@@ -40,6 +49,18 @@ func (a *App) Startup(ctx context.Context) {
 	// Log cache status
 	cachePath := a.cacheManager.GetCachePath()
 	fmt.Printf("Cache location: %s\n", cachePath)
+
+	// Keep the codes fresh while the window is open
+	a.lifetime, a.cancel = context.WithCancel(ctx)
+	a.startBackgroundRefresh(a.lifetime)
+}
+
+// Shutdown is called when the app is closing; it stops the background
+// refresh and cancels API requests still in flight
+func (a *App) Shutdown(ctx context.Context) {
+	if a.cancel != nil {
+		a.cancel()
+	}
 }
 
 // Greet returns a greeting for the given name
@@ -54,12 +75,12 @@ func LoadWeaponCodesFromExcel(a *App) ([]WeaponCode, error) {
 }
 
 // GetWeaponCodes returns all weapon modification codes
-// In production, it loads from local cache
+// In production, it reads the cache; the background refresh keeps it current
 // In development (if enableExcel is true), it can load from Excel
 func (a *App) GetWeaponCodes() []WeaponCode {
-	// Try loading from cache first
-	codes, found, err := a.cacheManager.Load()
-	if err == nil && found {
+	// Read the cache only, fetching is left to the background refresh
+	codes, err := a.codeLoader.Cached()
+	if err == nil {
 		return codes
 	}
 
@@ -88,16 +109,12 @@ func (a *App) GetWeaponCodes() []WeaponCode {
 }
 
 // GetWeaponCodesFromDaoZai returns weapon codes from 刀仔 data source
-// Filters cached data by source "刀仔"
+// Filters the loaded data by source "刀仔"
 func (a *App) GetWeaponCodesFromDaoZai() []WeaponCode {
-	// Try loading from cache first
-	codes, found, err := a.cacheManager.Load()
-	fmt.Printf("[DEBUG] GetWeaponCodesFromDaoZai: found=%v, err=%v, cachePath=%s\n", found, err, a.cacheManager.GetCachePath())
-	if err == nil && found {
-		fmt.Printf("[DEBUG] Loaded %d codes from cache\n", len(codes))
-		result := filterBySource(codes, "刀仔")
-		fmt.Printf("[DEBUG] Filtered to %d codes from 刀仔\n", len(result))
-		return result
+	// Read the cache only, fetching is left to the background refresh
+	codes, err := a.codeLoader.Cached()
+	if err == nil {
+		return filterBySource(codes, "刀仔")
 	}
 
 	// If cache not found and Excel is enabled, load from Excel
@@ -120,16 +137,12 @@ func (a *App) GetWeaponCodesFromDaoZai() []WeaponCode {
 }
 
 // GetWeaponCodesFromWeaponMaster returns weapon codes from 武器大师 data source
-// Filters cached data by source "武器大师"
+// Filters the loaded data by source "武器大师"
 func (a *App) GetWeaponCodesFromWeaponMaster() []WeaponCode {
-	// Try loading from cache first
-	codes, found, err := a.cacheManager.Load()
-	fmt.Printf("[DEBUG] GetWeaponCodesFromWeaponMaster: found=%v, err=%v, cachePath=%s\n", found, err, a.cacheManager.GetCachePath())
-	if err == nil && found {
-		fmt.Printf("[DEBUG] Loaded %d codes from cache\n", len(codes))
-		result := filterBySource(codes, "武器大师")
-		fmt.Printf("[DEBUG] Filtered to %d codes from 武器大师\n", len(result))
-		return result
+	// Read the cache only, fetching is left to the background refresh
+	codes, err := a.codeLoader.Cached()
+	if err == nil {
+		return filterBySource(codes, "武器大师")
 	}
 
 	// If cache not found and Excel is enabled, load from Excel
@@ -164,28 +177,46 @@ func filterBySource(codes []WeaponCode, source string) []WeaponCode {
 
 // GetCacheInfo returns information about the current cache
 func (a *App) GetCacheInfo() map[string]interface{} {
-	codes, found, err := a.cacheManager.Load()
+	cache, err := a.cacheManager.Inspect()
 	info := map[string]interface{}{
 		"cache_path":   a.cacheManager.GetCachePath(),
-		"cache_found":  found,
+		"cache_found":  err == nil,
 		"cache_loaded": err == nil,
 		"version":      CacheVersion,
+		"paths":        a.data.Paths.Map(),
+		"store":        a.cacheManager.StoreKind(),
+	}
+
+	// Integrity of the loaded cache; a mismatch means the file was edited by hand
+	integrity := a.cacheManager.Integrity()
+	info["integrity"] = integrity.Status
+	if integrity.Status == IntegrityMismatch {
+		info["integrity_warning"] = integrity.Message()
+	}
+
+	if err == nil {
+		info["code_count"] = len(cache.WeaponCodes)
+		info["last_updated"] = cache.LastUpdated
+		if cache.CheckedAt != "" {
+			info["checked_at"] = cache.CheckedAt
+		}
+		sources := cache.SourceTable()
+		info["sources"] = sources
+		// Count by source, from the envelope's source table
+		sourceCounts := make(map[string]int, len(sources))
+		for _, meta := range sources {
+			sourceCounts[meta.Name] = meta.RowCount
+		}
+		info["source_counts"] = sourceCounts
 	}
 
-	if err == nil && found {
-		info["code_count"] = len(codes)
-		// Count by source
-		daoZaiCount := 0
-		weaponMasterCount := 0
-		for _, code := range codes {
-			if code.Source == "刀仔" {
-				daoZaiCount++
-			} else if code.Source == "武器大师" {
-				weaponMasterCount++
-			}
+	// Health and circuit breaker of each API mirror, if an API is configured
+	if mirrors := a.codeLoader.MirrorStatus(); len(mirrors) > 0 {
+		info["mirrors"] = mirrors
+		if err == nil {
+			// The mirror that served the cached codes
+			info["mirror"] = cache.Endpoints[a.codeLoader.endpoint()].Mirror
 		}
-		info["dao_zai_count"] = daoZaiCount
-		info["weapon_master_count"] = weaponMasterCount
 	}
 
 	if a.enableExcel {
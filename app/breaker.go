@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed = "closed"
+	// BreakerOpen rejects requests until the cooldown has passed
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets a single trial request through after the cooldown
+	BreakerHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned instead of contacting an endpoint that keeps failing
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerConfig controls when a CircuitBreaker opens
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failed requests that opens it
+	Threshold int
+	// Cooldown is how long it stays open before a trial request
+	Cooldown time.Duration
+}
+
+// DefaultBreakerConfig is used when a DataSourceConfig does not set one
+var DefaultBreakerConfig = BreakerConfig{
+	Threshold: 5,
+	Cooldown:  time.Minute,
+}
+
+// BreakerStatus is a snapshot of a circuit breaker, as shown in GetCacheInfo
+type BreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	// OpenedAt and RetryAt are set while the breaker is open (RFC 3339)
+	OpenedAt string `json:"opened_at,omitempty"`
+	RetryAt  string `json:"retry_at,omitempty"`
+}
+
+// CircuitBreaker stops requests to an endpoint after repeated failures
+type CircuitBreaker struct {
+	config BreakerConfig
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	lastError string
+	openedAt  time.Time
+	// trial is true while the half-open trial request is in flight
+	trial bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBreakerConfig.Threshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultBreakerConfig.Cooldown
+	}
+	return &CircuitBreaker{config: config, state: BreakerClosed}
+}
+
+// Allow returns ErrCircuitOpen if a request must not be sent now
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(b.config.Cooldown)
+		if time.Now().Before(retryAt) {
+			return fmt.Errorf("%w until %s: %s", ErrCircuitOpen, formatCacheTime(retryAt), b.lastError)
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		if b.trial {
+			return fmt.Errorf("%w: trial request in progress", ErrCircuitOpen)
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success records a request that reached a healthy endpoint
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.lastError = ""
+	b.trial = false
+}
+
+// Failure records a request that failed after all retries
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.trial = false
+
+	if b.state == BreakerHalfOpen || b.failures >= b.config.Threshold {
+		if b.state != BreakerOpen {
+			fmt.Printf("Circuit breaker opened after %d failures: %v\n", b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends a request that neither succeeded nor failed, such as one
+// cancelled by its caller
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// Status returns a snapshot of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if b.state == BreakerOpen {
+		status.OpenedAt = formatCacheTime(b.openedAt)
+		status.RetryAt = formatCacheTime(b.openedAt.Add(b.config.Cooldown))
+	}
+	return status
+}
@@ -2,92 +2,106 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
 	// Current cache version
-	CacheVersion = "1.0.0"
-	// Cache filename
+	// 1.1.0: RFC 3339 timestamps and per-source fetch times
+	// 1.2.0: per-source metadata table replaces source_hashes
+	// 1.3.0: HTTP validators per endpoint and the last "not modified" check time
+	CacheVersion = "1.3.0"
+	// Cache filename (plain JSON, used for exports meant to be read by humans)
 	CacheFileName = "weapon_codes.json"
+	// CompressedCacheFileName is the gzip-compressed cache that is embedded and stored
+	CompressedCacheFileName = CacheFileName + ".gz"
+	// legacyCacheVersion is the first cache version, which numbered the rows
+	// of each source and wrote zone-less timestamps
+	legacyCacheVersion = "1.0.0"
 )
 
 // WeaponCodeCache represents the cache structure with version control
 type WeaponCodeCache struct {
-	Version     string       `json:"version"`
-	LastUpdated string       `json:"last_updated"`
-	TotalCount  int          `json:"total_count"`
-	DataSource  string       `json:"data_source"` // "local", "api", etc.
-	WeaponCodes []WeaponCode `json:"weapon_codes"`
+	Version     string `json:"version"`
+	LastUpdated string `json:"last_updated"` // RFC 3339
+	TotalCount  int    `json:"total_count"`
+	DataSource  string `json:"data_source"` // "local", "api", etc.
+	// Sources holds the metadata of each data source, including its content hash
+	Sources []SourceMeta `json:"sources,omitempty"`
+	// ContentHash is the SHA-256 hash of WeaponCodes, written by Save and checked by Load
+	ContentHash string `json:"content_hash,omitempty"`
+	// CheckedAt is when the API last confirmed the data is current (RFC 3339)
+	CheckedAt string `json:"checked_at,omitempty"`
+	// Endpoints holds the HTTP validators of each API endpoint the data came from
+	Endpoints map[string]EndpointMeta `json:"endpoints,omitempty"`
+	// PackCreatedAt is the creation time of the newest signed data pack the
+	// cache has taken (RFC 3339). Older packs are replays; saves never lower it.
+	PackCreatedAt string       `json:"pack_created_at,omitempty"`
+	WeaponCodes   []WeaponCode `json:"weapon_codes"`
 }
 
 // CacheManager manages the weapon codes cache
 type CacheManager struct {
-	cachePath string
-	mu        sync.RWMutex
+	store cacheStore
+	mu    sync.RWMutex
+	// compress makes Save write gzip; Load detects gzip by its magic bytes
+	compress bool
+
+	// integrity is the result of verifying the last loaded cache
+	integrity   IntegrityReport
+	integrityMu sync.Mutex
 }
 
-// NewCacheManager creates a new cache manager
-func NewCacheManager() *CacheManager {
+// NewCacheManager creates a new cache manager for the given cache file
+// Files ending in ".gz" are written gzip-compressed.
+func NewCacheManager(cachePath string) *CacheManager {
 	return &CacheManager{
-		cachePath: getCacheFilePath(),
+		store:    &fileStore{path: cachePath},
+		compress: strings.HasSuffix(cachePath, ".gz"),
 	}
 }
 
-// getCacheFilePath returns the path to the cache file
-// It checks multiple locations: local data dir, executable dir, etc.
-func getCacheFilePath() string {
-	possiblePaths := []string{
-		filepath.Join("data", CacheFileName),
-		CacheFileName,
-	}
-
-	// Check executable directory
-	exePath, err := os.Executable()
-	if err == nil {
-		exeDir := filepath.Dir(exePath)
-		possiblePaths = append(possiblePaths, filepath.Join(exeDir, CacheFileName))
-		possiblePaths = append(possiblePaths, filepath.Join(exeDir, "data", CacheFileName))
-		possiblePaths = append(possiblePaths, filepath.Join(filepath.Dir(exeDir), "data", CacheFileName))
+// NewCacheManagerFromBytes creates a cache manager serving data from memory,
+// such as the embedded default cache. It never touches the filesystem:
+// Save only replaces the in-memory copy.
+func NewCacheManagerFromBytes(data []byte) *CacheManager {
+	return &CacheManager{
+		store: &memoryStore{data: data},
 	}
+}
 
-	// Return first existing path, or default to data dir
-	for _, path := range possiblePaths {
-		if _, err := os.Stat(path); err == nil {
-			return path
-		}
+// NewCacheManagerFromFS creates a read-only cache manager reading the named
+// file from fsys (for example an embed.FS or a test filesystem)
+func NewCacheManagerFromFS(fsys fs.FS, name string) *CacheManager {
+	return &CacheManager{
+		store: &fsStore{fsys: fsys, name: name},
 	}
-
-	// Default to data directory in current working directory
-	return filepath.Join("data", CacheFileName)
 }
 
 // Load loads weapon codes from cache
 // Returns the codes and a boolean indicating if cache was used
 func (cm *CacheManager) Load() ([]WeaponCode, bool, error) {
-	cm.mu.RLock()
-	defer cm.mu.RUnlock()
-
-	// Check if cache file exists
-	if _, err := os.Stat(cm.cachePath); os.IsNotExist(err) {
-		return nil, false, fmt.Errorf("cache file not found")
-	}
-
-	// Read cache file
-	data, err := os.ReadFile(cm.cachePath)
+	cache, err := cm.LoadEnvelope()
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to read cache file: %w", err)
+		return nil, false, err
 	}
+	return cache.WeaponCodes, true, nil
+}
 
-	// Parse cache
-	var cache WeaponCodeCache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return nil, false, fmt.Errorf("failed to parse cache file: %w", err)
+// LoadEnvelope loads the whole cache structure, including its metadata
+// The content hashes are verified; a mismatch is reported through
+// Integrity but the data is still returned.
+func (cm *CacheManager) LoadEnvelope() (*WeaponCodeCache, error) {
+	cache, err := cm.Inspect()
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate cache version (for future compatibility)
@@ -95,65 +109,343 @@ func (cm *CacheManager) Load() ([]WeaponCode, bool, error) {
 		// Version mismatch, but we can still try to use it
 		fmt.Printf("Warning: Cache version mismatch. Expected %s, got %s\n", CacheVersion, cache.Version)
 	}
+	if report := cm.Integrity(); report.Status == IntegrityMismatch {
+		fmt.Printf("Warning: Cache integrity check failed: %s\n", report.Message())
+	}
 
 	fmt.Printf("Loaded %d weapon codes from cache (version: %s, updated: %s)\n",
 		len(cache.WeaponCodes), cache.Version, cache.LastUpdated)
 
-	return cache.WeaponCodes, true, nil
+	return cache, nil
+}
+
+// Inspect is LoadEnvelope without logging, for callers that read the
+// cache on every request
+func (cm *CacheManager) Inspect() (*WeaponCodeCache, error) {
+	cache, err := cm.readEnvelope()
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify content hashes to detect hand-edited or corrupted files
+	cm.setIntegrity(verifyCacheIntegrity(cache))
+	return cache, nil
+}
+
+// readEnvelope reads and parses the cache without verifying or logging
+func (cm *CacheManager) readEnvelope() (*WeaponCodeCache, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.readEnvelopeLocked()
+}
+
+// readEnvelopeLocked is readEnvelope for callers already holding cm.mu
+func (cm *CacheManager) readEnvelopeLocked() (*WeaponCodeCache, error) {
+	// Read cache data
+	data, err := cm.store.Read()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cache file not found: %w", fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	return decodeEnvelope(data)
+}
+
+// decodeEnvelope parses a plain or gzip-compressed cache
+// Entries of legacy caches are given stable IDs, see migrateLegacyIDs.
+func decodeEnvelope(data []byte) (*WeaponCodeCache, error) {
+	cache, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	migrateLegacyIDs(cache)
+	return cache, nil
+}
+
+// parseEnvelope parses a plain or gzip-compressed cache as stored
+func parseEnvelope(data []byte) (*WeaponCodeCache, error) {
+	// Compressed and plain caches are both accepted
+	data, err := maybeGunzip(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	// Parse cache
+	var cache WeaponCodeCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse cache file: %w", err)
+	}
+	return &cache, nil
 }
 
 // Save saves weapon codes to cache
-func (cm *CacheManager) Save(codes []WeaponCode, dataSource string) error {
+// Metadata for each source can be passed in sources; any source present in
+// codes but not in sources is recorded as fetched now. The HTTP validators
+// recorded by earlier API fetches are dropped, since they no longer describe
+// the stored codes.
+func (cm *CacheManager) Save(codes []WeaponCode, dataSource string, sources ...SourceMeta) error {
+	return cm.save(codes, dataSource, sources, "", EndpointMeta{})
+}
+
+// SaveFetched saves codes received from an API endpoint together with the
+// endpoint's validators. Both go into a single write, so the codes are never
+// stored without the validators that describe them.
+func (cm *CacheManager) SaveFetched(endpoint string, meta EndpointMeta, codes []WeaponCode, dataSource string, sources ...SourceMeta) error {
+	return cm.save(codes, dataSource, sources, endpoint, meta)
+}
+
+// save writes a new envelope for codes. Validators of the current envelope
+// are carried over only when endpoint is set, in which case its own
+// validators are replaced by meta.
+func (cm *CacheManager) save(codes []WeaponCode, dataSource string, sources []SourceMeta, endpoint string, meta EndpointMeta) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	// Ensure directory exists
-	dir := filepath.Dir(cm.cachePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
-	}
+	now := time.Now()
 
 	// Create cache structure
 	cache := WeaponCodeCache{
 		Version:     CacheVersion,
-		LastUpdated: time.Now().Format("2006-01-02 15:04:05"),
+		LastUpdated: formatCacheTime(now),
 		TotalCount:  len(codes),
 		DataSource:  dataSource,
+		Sources:     buildSourceMeta(codes, sources, now),
+		ContentHash: hashWeaponCodes(codes),
 		WeaponCodes: codes,
 	}
+	if previous, err := cm.readEnvelopeLocked(); err == nil {
+		cache.PackCreatedAt = previous.packHighWater()
+		if endpoint != "" {
+			cache.CheckedAt = previous.CheckedAt
+			cache.Endpoints = previous.Endpoints
+		}
+	}
+	if endpoint != "" {
+		if cache.Endpoints == nil {
+			cache.Endpoints = make(map[string]EndpointMeta)
+		}
+		cache.Endpoints[endpoint] = meta
+		cache.CheckedAt = meta.CheckedAt
+		cache.PackCreatedAt = laterCacheTime(cache.PackCreatedAt, meta.PackCreatedAt)
+	}
+
+	if err := cm.writeEnvelopeLocked(&cache); err != nil {
+		return err
+	}
 
+	fmt.Printf("Saved %d weapon codes to cache: %s\n", len(codes), cm.store.Location())
+
+	cm.setIntegrity(IntegrityReport{
+		Status:       IntegrityVerified,
+		ExpectedHash: cache.ContentHash,
+		ActualHash:   cache.ContentHash,
+	})
+	return nil
+}
+
+// writeEnvelopeLocked encodes and writes a cache; callers hold cm.mu
+func (cm *CacheManager) writeEnvelopeLocked(cache *WeaponCodeCache) error {
 	// Marshal to JSON with indentation for readability
 	data, err := json.MarshalIndent(cache, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal cache: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(cm.cachePath, data, 0644); err != nil {
+	if cm.compress {
+		if data, err = gzipBytes(data); err != nil {
+			return fmt.Errorf("failed to compress cache: %w", err)
+		}
+	}
+
+	// Write to the backing store
+	if err := cm.store.Write(data); err != nil {
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
+	return nil
+}
+
+// EndpointValidators returns the HTTP validators stored for an API endpoint
+// The result is empty when the cache is missing or has none for it.
+func (cm *CacheManager) EndpointValidators(endpoint string) EndpointMeta {
+	cache, err := cm.readEnvelope()
+	if err != nil {
+		return EndpointMeta{}
+	}
+	return cache.Endpoints[endpoint]
+}
+
+// PackHighWater returns the creation time of the newest signed data pack
+// the cache has taken, or "" when it never held one
+func (cm *CacheManager) PackHighWater() string {
+	cache, err := cm.readEnvelope()
+	if err != nil {
+		return ""
+	}
+	return cache.packHighWater()
+}
+
+// packHighWater returns PackCreatedAt, or for caches written before it was
+// recorded the newest pack creation time among the endpoints
+func (cache *WeaponCodeCache) packHighWater() string {
+	newest := cache.PackCreatedAt
+	for _, meta := range cache.Endpoints {
+		newest = laterCacheTime(newest, meta.PackCreatedAt)
+	}
+	return newest
+}
+
+// laterCacheTime returns the later of two envelope timestamps
+// Timestamps that cannot be parsed lose against valid ones.
+func laterCacheTime(a, b string) string {
+	ta, errA := parseCacheTime(a)
+	tb, errB := parseCacheTime(b)
+	switch {
+	case errB != nil:
+		return a
+	case errA != nil || tb.After(ta):
+		return b
+	default:
+		return a
+	}
+}
+
+// MarkChecked records that the API confirmed the cached data at meta.CheckedAt
+// and stores the endpoint's validators. The weapon codes are left untouched.
+func (cm *CacheManager) MarkChecked(endpoint string, meta EndpointMeta) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cache, err := cm.readEnvelopeLocked()
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Saved %d weapon codes to cache: %s\n", len(codes), cm.cachePath)
+	if cache.Endpoints == nil {
+		cache.Endpoints = make(map[string]EndpointMeta)
+	}
+	cache.Endpoints[endpoint] = meta
+	cache.CheckedAt = meta.CheckedAt
+	return cm.writeEnvelopeLocked(cache)
+}
+
+// Integrity returns the result of verifying the last loaded or saved cache
+func (cm *CacheManager) Integrity() IntegrityReport {
+	cm.integrityMu.Lock()
+	defer cm.integrityMu.Unlock()
+	if cm.integrity.Status == "" {
+		return IntegrityReport{Status: IntegrityUnknown}
+	}
+	return cm.integrity
+}
+
+// setIntegrity records the result of an integrity check
+func (cm *CacheManager) setIntegrity(report IntegrityReport) {
+	cm.integrityMu.Lock()
+	defer cm.integrityMu.Unlock()
+	cm.integrity = report
+}
+
+// ExportJSON writes the cache as plain, indented JSON for human inspection
+func (cm *CacheManager) ExportJSON(w io.Writer) error {
+	cache, err := cm.LoadEnvelope()
+	if err != nil {
+		return err
+	}
 
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
 	return nil
 }
 
 // GetCachePath returns the current cache file path
+// For stores that are not files it returns a description such as "(memory)"
 func (cm *CacheManager) GetCachePath() string {
-	return cm.cachePath
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.store.Location()
+}
+
+// StoreKind returns which backing store is active: "file", "memory" or "fs"
+func (cm *CacheManager) StoreKind() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.store.Kind()
+}
+
+// UseEmbedded switches the cache manager to serve data from memory
+// Nothing is written to disk after this call.
+func (cm *CacheManager) UseEmbedded(data []byte) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.store = &memoryStore{data: data}
+}
+
+// MigratePlainCache moves the plain JSON cache older versions kept at
+// legacyPath into this cache. The envelope is copied as is apart from
+// stable IDs, so its fetch times and hashes survive. When this cache already
+// exists the legacy file is stale and only removed; a legacy file that
+// cannot be parsed is kept, and so is any file when keep is set.
+func (cm *CacheManager) MigratePlainCache(legacyPath string, keep bool) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// Nothing to do for memory stores or when legacyPath is this cache
+	if cm.store.Kind() != StoreKindFile || isInDir(legacyPath, cm.store.Location()) {
+		return nil
+	}
+	data, err := os.ReadFile(legacyPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read legacy cache: %w", err)
+	}
+
+	if _, err := cm.store.ModTime(); err != nil {
+		cache, err := decodeEnvelope(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse legacy cache %s: %w", legacyPath, err)
+		}
+		if err := cm.writeEnvelopeLocked(cache); err != nil {
+			return err
+		}
+		fmt.Printf("Migrated legacy cache %s to %s\n", legacyPath, cm.store.Location())
+	}
+
+	if keep {
+		return nil
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		return fmt.Errorf("failed to remove legacy cache: %w", err)
+	}
+	return nil
 }
 
 // IsCacheExpired checks if the cache is older than the specified duration
+// The age comes from the fetch times recorded in the cache itself, so
+// copying or re-extracting the file does not make it look fresh.
 func (cm *CacheManager) IsCacheExpired(maxAge time.Duration) (bool, error) {
-	info, err := os.Stat(cm.cachePath)
+	cache, err := cm.readEnvelope()
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return true, nil // Cache doesn't exist, consider it expired
 		}
 		return false, err
 	}
 
-	age := time.Since(info.ModTime())
+	fetched, err := cache.freshAsOf()
+	if err != nil {
+		return true, nil // Unknown age, consider it expired
+	}
+
+	age := time.Since(fetched)
 	return age > maxAge, nil
 }
 
@@ -162,95 +454,49 @@ func (cm *CacheManager) ClearCache() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if err := os.Remove(cm.cachePath); err != nil && !os.IsNotExist(err) {
+	if err := cm.store.Remove(); err != nil {
 		return fmt.Errorf("failed to remove cache file: %w", err)
 	}
 
-	fmt.Printf("Cache file removed: %s\n", cm.cachePath)
+	fmt.Printf("Cache file removed: %s\n", cm.store.Location())
 	return nil
 }
 
 // InitializeFromEmbedded initializes the cache from embedded data
-// On Windows: Skips initialization (NSIS installer copies data\weapon_codes.json)
-// On macOS/Linux: Extracts embedded JSON to user config directory on first run
+// If the cache file does not exist yet (first run), the embedded data is
+// extracted to it.
+// Stores that are not files are left untouched.
 func (cm *CacheManager) InitializeFromEmbedded(data []byte) error {
 	if len(data) == 0 {
 		return fmt.Errorf("no embedded data provided")
 	}
 
-	// On Windows, NSIS installer will copy the file, so we don't need to extract
-	// Just set the cache path correctly
-	if runtime.GOOS == "windows" {
-		cm.cachePath = cm.getWritableCachePath()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.store.Kind() != StoreKindFile {
 		return nil
 	}
 
-	// On macOS/Linux, extract embedded data to user config directory
-	cachePath := cm.getWritableCachePath()
-	cm.cachePath = cachePath
-
 	// Check if cache file already exists at the writable location
-	if _, err := os.Stat(cachePath); err == nil {
-		// Cache file exists, verify it's valid
+	if _, err := cm.store.ModTime(); err == nil {
 		return nil
 	}
 
-	// Ensure directory exists
-	dir := filepath.Dir(cachePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
+	// Legacy embedded data is written with stable IDs; anything else as is
+	if cache, err := parseEnvelope(data); err == nil && migrateLegacyIDs(cache) {
+		if err := cm.writeEnvelopeLocked(cache); err != nil {
+			return fmt.Errorf("failed to write embedded cache: %w", err)
+		}
+		fmt.Printf("Initialized cache from embedded data: %s\n", cm.store.Location())
+		return nil
 	}
 
 	// Write embedded data to cache file
-	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+	if err := cm.store.Write(data); err != nil {
 		return fmt.Errorf("failed to write embedded cache: %w", err)
 	}
 
-	fmt.Printf("Initialized cache from embedded data: %s\n", cachePath)
+	fmt.Printf("Initialized cache from embedded data: %s\n", cm.store.Location())
 	return nil
 }
-
-// getWritableCachePath returns a writable path for the cache file
-// On Windows: <InstallDir>\data\weapon_codes.json (from executable directory)
-// On macOS: ~/Library/Application Support/delta-tool/weapon_codes.json
-// On Linux: ~/.config/delta-tool/weapon_codes.json
-func (cm *CacheManager) getWritableCachePath() string {
-	// On Windows, use executable directory\data subdirectory
-	if runtime.GOOS == "windows" {
-		exePath, err := os.Executable()
-		if err == nil {
-			exeDir := filepath.Dir(exePath)
-			return filepath.Join(exeDir, "data", CacheFileName)
-		}
-	}
-
-	// On macOS/Linux, use user config directory
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		// Fallback to executable directory if home dir not available
-		return cm.getExecutableDirPath()
-	}
-
-	var configDir string
-	switch runtime.GOOS {
-	case "darwin":
-		// macOS: ~/Library/Application Support/delta-tool
-		configDir = filepath.Join(homeDir, "Library", "Application Support", "delta-tool")
-	default: // Linux and others (freebsd, openbsd, etc.)
-		// Linux: ~/.config/delta-tool
-		configDir = filepath.Join(homeDir, ".config", "delta-tool")
-	}
-
-	return filepath.Join(configDir, CacheFileName)
-}
-
-// getExecutableDirPath returns the path relative to executable directory
-func (cm *CacheManager) getExecutableDirPath() string {
-	exePath, err := os.Executable()
-	if err != nil {
-		// Last resort: current working directory
-		return CacheFileName
-	}
-	exeDir := filepath.Dir(exePath)
-	return filepath.Join(exeDir, CacheFileName)
-}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompressedCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CompressedCacheFileName)
+	cm := NewCacheManager(path)
+	if err := cm.Save(testCodes(1), "local-excel"); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isGzip(raw) {
+		t.Fatalf("%s is not gzip-compressed", path)
+	}
+	loaded, _, err := NewCacheManager(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashWeaponCodes(loaded) != hashWeaponCodes(testCodes(1)) {
+		t.Errorf("loaded codes differ from saved ones")
+	}
+
+	// A plain cache under the compressed name still loads
+	plain, err := maybeGunzip(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _, err := NewCacheManager(path).Load(); err != nil || len(loaded) != len(testCodes(1)) {
+		t.Errorf("plain cache: %d codes, err %v", len(loaded), err)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := cm.Save(testCodes(1), "local-excel"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cm.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var cache WeaponCodeCache
+	if err := json.Unmarshal(buf.Bytes(), &cache); err != nil {
+		t.Fatalf("export is not JSON: %v", err)
+	}
+	if cache.DataSource != "local-excel" || hashWeaponCodes(cache.WeaponCodes) != cache.ContentHash {
+		t.Errorf("exported envelope %+v", cache)
+	}
+}
+
+func TestIsCacheExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CacheFileName)
+	cm := NewCacheManager(path)
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || !expired {
+		t.Errorf("missing cache: expired %v, err %v", expired, err)
+	}
+
+	if err := cm.Save(testCodes(1), "api"); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || expired {
+		t.Errorf("fresh cache: expired %v, err %v", expired, err)
+	}
+
+	// A copied or re-extracted file looks new but carries old fetch times
+	old := formatCacheTime(time.Now().Add(-48 * time.Hour))
+	recent := formatCacheTime(time.Now().Add(-time.Minute))
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.Sources[0].FetchedAt = old
+	})
+	if expired, err := cm.IsCacheExpired(24 * time.Hour); err != nil || !expired {
+		t.Errorf("old source: expired %v, err %v", expired, err)
+	}
+
+	// A recent "not modified" answer vouches for the old data
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.CheckedAt = recent
+	})
+	if expired, err := cm.IsCacheExpired(24 * time.Hour); err != nil || expired {
+		t.Errorf("checked recently: expired %v, err %v", expired, err)
+	}
+
+	// Caches without a source table fall back to their zone-less LastUpdated
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.Sources = nil
+		cache.CheckedAt = ""
+		cache.LastUpdated = time.Now().Add(-2 * time.Hour).Format(legacyTimeLayout)
+	})
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || !expired {
+		t.Errorf("legacy timestamp: expired %v, err %v", expired, err)
+	}
+	if expired, err := cm.IsCacheExpired(3 * time.Hour); err != nil || expired {
+		t.Errorf("legacy timestamp: expired %v, err %v", expired, err)
+	}
+
+	// An unreadable age counts as expired
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.LastUpdated = "yesterday"
+	})
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || !expired {
+		t.Errorf("bad timestamp: expired %v, err %v", expired, err)
+	}
+}
+
+func TestMigratePlainCache(t *testing.T) {
+	dir := t.TempDir()
+	legacy := NewCacheManager(filepath.Join(dir, CacheFileName))
+	if err := legacy.Save(testCodes(1), "api"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := legacy.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewCacheManager(filepath.Join(dir, CompressedCacheFileName))
+	if err := cm.MigratePlainCache(legacy.GetCachePath(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy.GetCachePath()); !os.IsNotExist(err) {
+		t.Errorf("legacy cache left behind (err %v)", err)
+	}
+	raw, err := os.ReadFile(cm.GetCachePath())
+	if err != nil || !isGzip(raw) {
+		t.Fatalf("migrated cache not compressed (err %v)", err)
+	}
+	after, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.LastUpdated != before.LastUpdated || after.DataSource != "api" || cm.Integrity().Status != IntegrityVerified {
+		t.Errorf("envelope not kept: %+v", after)
+	}
+
+	// Nothing to do the second time
+	if err := cm.MigratePlainCache(legacy.GetCachePath(), false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigratePlainCacheRemovesStaleCopy(t *testing.T) {
+	dir := t.TempDir()
+	cm := NewCacheManager(filepath.Join(dir, CompressedCacheFileName))
+	if err := cm.Save(testCodes(2), "api"); err != nil {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(dir, CacheFileName)
+	if err := NewCacheManager(legacyPath).Save(testCodes(1), "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.MigratePlainCache(legacyPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("stale legacy cache left behind (err %v)", err)
+	}
+	codes, _, err := cm.Load()
+	if err != nil || hashCodeSet(codes) != hashCodeSet(testCodes(2)) {
+		t.Errorf("current cache replaced by the legacy one (err %v)", err)
+	}
+
+	// A legacy file that cannot be read is left for the user
+	if err := os.WriteFile(legacyPath, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(cm.GetCachePath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.MigratePlainCache(legacyPath, false); err == nil {
+		t.Error("broken legacy cache migrated")
+	}
+	if _, err := os.Stat(legacyPath); err != nil {
+		t.Errorf("broken legacy cache removed: %v", err)
+	}
+}
+
+func TestLegacyCacheGetsStableIDs(t *testing.T) {
+	// Version 1.0.0 numbered the rows of each source from 0
+	codes := testCodes(1)
+	codes[0].ID, codes[1].ID, codes[2].ID = "0", "0", "1"
+	data, err := json.Marshal(WeaponCodeCache{
+		Version:     legacyCacheVersion,
+		LastUpdated: "2026-01-19 18:03:55",
+		TotalCount:  len(codes),
+		DataSource:  "local-excel",
+		ContentHash: hashWeaponCodes(codes),
+		WeaponCodes: codes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCacheManagerFromBytes(data).LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range cache.WeaponCodes {
+		if code.ID != stableID(code) {
+			t.Errorf("%s %s: id %q, want %q", code.Source, code.Name, code.ID, stableID(code))
+		}
+	}
+	if problems := validateDataset(nil, cache.WeaponCodes, ValidationConfig{}); len(problems) > 0 {
+		t.Errorf("migrated cache rejected: %v", problems)
+	}
+	if report := verifyCacheIntegrity(cache); report.Status != IntegrityVerified {
+		t.Errorf("migrated cache does not verify: %s", report.Message())
+	}
+
+	// The cache extracted on first run carries the stable IDs too
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := cm.InitializeFromEmbedded(data); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(cm.GetCachePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := parseEnvelope(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.WeaponCodes, cache.WeaponCodes) {
+		t.Errorf("extracted codes %+v, want %+v", stored.WeaponCodes, cache.WeaponCodes)
+	}
+}
+
+// TestBundledCache checks the data shipped in data/ as it is stored,
+// without the migrations applied on load
+func TestBundledCache(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("..", "data", CompressedCacheFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := parseEnvelope(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Version != CacheVersion {
+		t.Errorf("bundled data is version %s, want %s", cache.Version, CacheVersion)
+	}
+	if _, err := time.Parse(time.RFC3339, cache.LastUpdated); err != nil {
+		t.Errorf("bundled timestamp is not RFC 3339: %v", err)
+	}
+
+	// Every source has its metadata; source_hashes was replaced by the table
+	if len(cache.Sources) != len(knownSources) {
+		t.Errorf("bundled data has %d sources in its table, want %d", len(cache.Sources), len(knownSources))
+	}
+	for _, meta := range cache.Sources {
+		if meta.FetchedAt == "" || meta.ContentHash == "" {
+			t.Errorf("source %s: incomplete metadata %+v", meta.Name, meta)
+		}
+	}
+	if plain, err := maybeGunzip(raw); err != nil || bytes.Contains(plain, []byte(`"source_hashes"`)) {
+		t.Errorf("bundled data still has source_hashes (err %v)", err)
+	}
+
+	if problems := validateDataset(nil, cache.WeaponCodes, ValidationConfig{}); len(problems) > 0 {
+		t.Errorf("bundled data rejected: %v", problems)
+	}
+	if migrateLegacyIDs(cache) {
+		t.Error("bundled data has no stable IDs")
+	}
+	if report := verifyCacheIntegrity(cache); report.Status != IntegrityVerified {
+		t.Errorf("bundled data: %s", report.Message())
+	}
+
+	// The readable copy holds the same data
+	plain, err := os.ReadFile(filepath.Join("..", "data", CacheFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := parseEnvelope(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.ContentHash != cache.ContentHash {
+		t.Errorf("%s is out of date: hash %s, want %s", CacheFileName, export.ContentHash, cache.ContentHash)
+	}
+}
+
+func TestSaveDropsValidators(t *testing.T) {
+	var conditional atomic.Bool
+	codes := testCodes(1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" || r.URL.Query().Has(SinceParam) {
+			conditional.Store(true)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Version: "v1", Data: codes})
+	}))
+	defer ts.Close()
+
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	loader := NewWeaponCodeLoaderWithConfig(cm, DataSourceConfig{UseLocalCache: true, APIBaseURL: ts.URL})
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cm.EndpointValidators(WeaponCodesPath).ETag != `"v1"` {
+		t.Fatal("validators of the fetch not stored")
+	}
+
+	// Codes regenerated from Excel are not what the API served, so its
+	// validators must not be sent with the next request
+	if err := cm.Save(testCodes(2), "local-excel"); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Endpoints) != 0 || cache.CheckedAt != "" {
+		t.Errorf("validators kept on save: %+v, checked at %q", cache.Endpoints, cache.CheckedAt)
+	}
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conditional.Load() {
+		t.Error("request after a local save was conditional")
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backing store kinds reported by CacheManager.StoreKind
+const (
+	StoreKindFile   = "file"
+	StoreKindMemory = "memory"
+	StoreKindFS     = "fs"
+)
+
+// ErrReadOnlyStore is returned when writing to a store that cannot be written
+var ErrReadOnlyStore = errors.New("cache store is read-only")
+
+// cacheStore is the storage behind a CacheManager.
+// Read returns an error wrapping fs.ErrNotExist when there is no data yet.
+type cacheStore interface {
+	Kind() string
+	Location() string
+	Read() ([]byte, error)
+	Write(data []byte) error
+	ModTime() (time.Time, error)
+	Remove() error
+}
+
+// fileStore keeps the cache in a file on disk
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) Kind() string     { return StoreKindFile }
+func (s *fileStore) Location() string { return s.path }
+
+func (s *fileStore) Read() ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s *fileStore) Write(data []byte) error {
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write a temporary file and rename it over the cache, so readers
+	// (such as the serve command) never see a half-written file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *fileStore) ModTime() (time.Time, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (s *fileStore) Remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// memoryStore keeps the cache in memory only; nothing touches the filesystem.
+// Writes replace the in-memory copy and are lost when the process exits.
+type memoryStore struct {
+	data    []byte
+	modTime time.Time
+}
+
+func (s *memoryStore) Kind() string     { return StoreKindMemory }
+func (s *memoryStore) Location() string { return "(memory)" }
+
+func (s *memoryStore) Read() ([]byte, error) {
+	if s.data == nil {
+		return nil, fmt.Errorf("memory cache is empty: %w", fs.ErrNotExist)
+	}
+	return s.data, nil
+}
+
+func (s *memoryStore) Write(data []byte) error {
+	s.data = data
+	s.modTime = time.Now()
+	return nil
+}
+
+func (s *memoryStore) ModTime() (time.Time, error) {
+	if s.data == nil {
+		return time.Time{}, fs.ErrNotExist
+	}
+	return s.modTime, nil
+}
+
+func (s *memoryStore) Remove() error {
+	s.data = nil
+	return nil
+}
+
+// fsStore reads the cache from a file in an fs.FS, such as an embed.FS.
+// It is read-only.
+type fsStore struct {
+	fsys fs.FS
+	name string
+}
+
+func (s *fsStore) Kind() string     { return StoreKindFS }
+func (s *fsStore) Location() string { return "fs:" + s.name }
+
+func (s *fsStore) Read() ([]byte, error) {
+	return fs.ReadFile(s.fsys, s.name)
+}
+
+func (s *fsStore) Write(data []byte) error {
+	return ErrReadOnlyStore
+}
+
+func (s *fsStore) ModTime() (time.Time, error) {
+	info, err := fs.Stat(s.fsys, s.name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (s *fsStore) Remove() error {
+	return ErrReadOnlyStore
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCacheManagerFromBytes(t *testing.T) {
+	cm := NewCacheManagerFromBytes(testCacheBytes(t))
+	if kind := cm.StoreKind(); kind != StoreKindMemory {
+		t.Errorf("store = %s, want %s", kind, StoreKindMemory)
+	}
+	if codes, found, err := cm.Load(); err != nil || !found || len(codes) != len(testCodes(1)) {
+		t.Fatalf("load: %d codes, found %v, err %v", len(codes), found, err)
+	}
+
+	// Saving replaces the in-memory copy only
+	if err := cm.Save(testCodes(2), "api"); err != nil {
+		t.Fatal(err)
+	}
+	if codes, _, _ := cm.Load(); len(codes) == 0 || codes[0].Code != testCodes(2)[0].Code {
+		t.Errorf("saved codes not served: %v", codes)
+	}
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || expired {
+		t.Errorf("fresh memory cache expired = %v (err %v)", expired, err)
+	}
+
+	if err := cm.ClearCache(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := cm.Load(); err == nil || found {
+		t.Error("cleared memory cache still loads")
+	}
+}
+
+func TestCacheManagerFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/" + CacheFileName: {Data: testCacheBytes(t), ModTime: time.Now()},
+	}
+	cm := NewCacheManagerFromFS(fsys, "data/"+CacheFileName)
+	if kind := cm.StoreKind(); kind != StoreKindFS {
+		t.Errorf("store = %s, want %s", kind, StoreKindFS)
+	}
+	codes, found, err := cm.Load()
+	if err != nil || !found || len(codes) != len(testCodes(1)) {
+		t.Fatalf("load: %d codes, found %v, err %v", len(codes), found, err)
+	}
+	if expired, err := cm.IsCacheExpired(time.Hour); err != nil || expired {
+		t.Errorf("fresh fs cache expired = %v (err %v)", expired, err)
+	}
+
+	if err := cm.Save(testCodes(2), "api"); !errors.Is(err, ErrReadOnlyStore) {
+		t.Errorf("save to fs store: %v, want %v", err, ErrReadOnlyStore)
+	}
+	if err := cm.ClearCache(); !errors.Is(err, ErrReadOnlyStore) {
+		t.Errorf("clear fs store: %v, want %v", err, ErrReadOnlyStore)
+	}
+
+	missing := NewCacheManagerFromFS(fsys, CacheFileName)
+	if _, found, err := missing.Load(); err == nil || found {
+		t.Error("missing file in fs loaded")
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import "strings"
+
+// weaponClassKeys maps weapon name fragments to their class
+// Keep in sync with getWeaponType in frontend/src/stores/weaponStore.ts.
+var weaponClassKeys = []struct{ key, class string }{
+	{"M4A1", "突击步枪"}, {"MK47", "突击步枪"}, {"K416", "突击步枪"}, {"KC17", "突击步枪"},
+	{"K437", "突击步枪"}, {"M4", "突击步枪"}, {"As-Val", "突击步枪"}, {"ASh-12", "突击步枪"},
+	{"SCAR-H", "突击步枪"}, {"AK-12", "突击步枪"}, {"AK-47", "突击步枪"}, {"FAMAS", "突击步枪"},
+	{"AUG", "突击步枪"}, {"QBZ", "突击步枪"}, {"QBZ-95", "突击步枪"}, {"Type-20", "突击步枪"},
+	{"MP5", "冲锋枪"}, {"MP7", "冲锋枪"}, {"MPX", "冲锋枪"}, {"P90", "冲锋枪"},
+	{"Vector", "冲锋枪"}, {"UZI", "冲锋枪"}, {"MAC-10", "冲锋枪"}, {"Skorpion", "冲锋枪"},
+	{"M14", "射手步枪"}, {"Mk14", "射手步枪"}, {"SR-25", "射手步枪"}, {"G28", "射手步枪"},
+	{"SCAR-HSSR", "射手步枪"}, {"DMR", "射手步枪"}, {"SVD", "射手步枪"},
+	{"M1911", "手枪"}, {"Glock", "手枪"}, {"P226", "手枪"}, {"DesertEagle", "手枪"},
+	{"Rex", "手枪"}, {"Magnum", "手枪"}, {"M9", "手枪"}, {"93R", "手枪"},
+	{"AWM", "狙击"}, {"M200", "狙击"}, {"M24", "狙击"}, {"Kar98k", "狙击"},
+	{"Mosin", "狙击"}, {"Lee-Enfield", "狙击"}, {"Lynx", "狙击"}, {"TAC-50", "狙击"},
+	{"Marlin", "狙击"},
+	{"M250", "机枪"}, {"M249", "机枪"}, {"PKM", "机枪"}, {"MG42", "机枪"},
+	{"M870", "霰弹枪"}, {"S12K", "霰弹枪"}, {"DBS", "霰弹枪"}, {"Shorty", "霰弹枪"},
+	{"Origin-12", "霰弹枪"}, {"AA-12", "霰弹枪"},
+	{"Crossbow", "弓弩"}, {"CompoundBow", "弓弩"}, {"Arbalist", "弓弩"},
+}
+
+// weaponClassFallbacks maps generic name fragments to a class when no
+// known weapon matched
+var weaponClassFallbacks = []struct {
+	keys  []string
+	class string
+}{
+	{[]string{"冲锋枪", "SMG"}, "冲锋枪"},
+	{[]string{"射手步枪", "DMR"}, "射手步枪"},
+	{[]string{"手枪", "PISTOL"}, "手枪"},
+	{[]string{"狙击", "SNIPER"}, "狙击"},
+	{[]string{"机枪", "MACHINEGUN", "LMG"}, "机枪"},
+	{[]string{"霰弹枪", "SHOTGUN"}, "霰弹枪"},
+	{[]string{"弓", "CROSSBOW", "ARBALIST"}, "弓弩"},
+	{[]string{"步枪", "RIFLE"}, "突击步枪"},
+}
+
+// weaponClass infers the weapon class (突击步枪, 冲锋枪, ...) from its name
+func weaponClass(name string) string {
+	upper := strings.ToUpper(name)
+	for _, entry := range weaponClassKeys {
+		if strings.Contains(upper, strings.ToUpper(entry.key)) {
+			return entry.class
+		}
+	}
+	for _, fallback := range weaponClassFallbacks {
+		for _, key := range fallback.keys {
+			if strings.Contains(upper, key) {
+				return fallback.class
+			}
+		}
+	}
+	return "其他"
+}
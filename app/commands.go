@@ -0,0 +1,629 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunCommand runs a command line subcommand against the shared data layer.
+// args[0] is the subcommand name, the rest are its arguments.
+func RunCommand(data *DataLayer, args []string) error {
+	switch args[0] {
+	case "generate-cache":
+		return runGenerateCache(data, args[1:])
+
+	case "paths":
+		return runPaths(data)
+
+	case "verify":
+		return runVerify(data, args[1:])
+
+	case "pack":
+		return runPack(data, args[1:])
+
+	case "export":
+		return runExport(data, args[1:])
+
+	case "serve":
+		return runServe(data, args[1:])
+
+	case "feed":
+		return runFeed(args[1:])
+
+	case "mock-api":
+		return runMockAPI(data, args[1:])
+
+	default:
+		fmt.Printf("Unknown command: %s\n", args[0])
+		printUsage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// printUsage prints the list of supported subcommands
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  delta-tool              # Run the GUI application")
+	fmt.Println("  delta-tool generate-cache [--source NAME] [--force]")
+	fmt.Println("                          # Generate cache from Excel files (dev only)")
+	fmt.Println("  delta-tool paths        # Print every resolved storage location")
+	fmt.Println("  delta-tool verify [FILE...]  # Check cache content hashes")
+	fmt.Println("  delta-tool export [--out FILE] [CACHEFILE]  # Write the cache as plain JSON (default: ./weapon_codes.json)")
+	fmt.Println("  delta-tool feed [--out FILE] [--url URL] [--weapon NAME] [--source NAME] [--mode MODE]")
+	fmt.Println("                  [--class CLASS] [--tier TIER] [--min-price N] [--max-price N] CACHEFILE CACHEFILE...")
+	fmt.Println("                          # Write an Atom feed of the codes added between cache snapshots, oldest first")
+	fmt.Println("  delta-tool serve [--addr ADDR] [--reload DURATION] [--admin-token-file FILE]")
+	fmt.Println("                   [--api-keys FILE] [--rate N] [--burst N] [--access-log FILE] [CACHEFILE]")
+	fmt.Println("                          # Serve the cache to other clients over HTTP (default: :8080)")
+	fmt.Println("  delta-tool mock-api [--addr ADDR] [--script LIST] [--scenario NAME] [--delay DURATION] [CACHEFILE...]")
+	fmt.Println("                          # Serve the cache as a scriptable mock API (" + mockScenarioNames() + ")")
+	fmt.Println("  delta-tool pack keygen --out KEYFILE  # Create a data pack signing key")
+	fmt.Println("  delta-tool pack sign --key KEYFILE [--out DIR] [--version V] [CACHEFILE]")
+	fmt.Println("                          # Sign the cache as a data pack")
+	fmt.Println("  delta-tool pack verify DIR  # Verify a data pack against the trusted keys")
+	fmt.Println()
+	fmt.Println("Global flags:")
+	fmt.Printf("  %s DIR        # Store all user data in DIR (same as %s)\n", DataDirFlag, HomeEnvVar)
+	fmt.Printf("  %s          # Keep all state next to the executable (same as a %s file there)\n", PortableFlag, PortableMarkerFile)
+	fmt.Printf("  %s         # Serve the embedded data from memory, never write to disk\n", ReadOnlyFlag)
+}
+
+// runGenerateCache converts the Excel source files into the JSON cache
+// Development mode only: the Excel files are not shipped with releases.
+// With --source only the named sources are refreshed; the others are kept.
+func runGenerateCache(data *DataLayer, args []string) error {
+	flags := flag.NewFlagSet("generate-cache", flag.ContinueOnError)
+	var opts GenerateOptions
+	flags.Func("source", "refresh only this source (repeatable or comma separated)", func(value string) error {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Sources = append(opts.Sources, name)
+			}
+		}
+		return nil
+	})
+	flags.BoolVar(&opts.Force, "force", false, "reparse workbooks even if they are unchanged")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if data.Paths.ReadOnly {
+		return fmt.Errorf("generate-cache writes %s and cannot run with %s", data.Paths.BundledCacheFile(), ReadOnlyFlag)
+	}
+
+	fmt.Println("========================================")
+	fmt.Println("  Excel to JSON Cache Converter")
+	fmt.Println("  Development Tool Only")
+	fmt.Println("========================================")
+	fmt.Println()
+
+	fmt.Println("Loading weapon codes from Excel files...")
+	result, err := regenerateFromExcel(data.Bundled, opts)
+	if err != nil {
+		return fmt.Errorf("failed to generate cache: %w", err)
+	}
+
+	for _, refresh := range result.Sources {
+		switch refresh.Status {
+		case RefreshUpdated:
+			fmt.Printf("  %s: updated (%d codes)\n", refresh.Name, refresh.Count)
+		case RefreshUnchanged:
+			fmt.Printf("  %s: unchanged, kept %d codes\n", refresh.Name, refresh.Count)
+		case RefreshMissing:
+			fmt.Printf("  %s: workbook not found, kept %d codes (%v)\n", refresh.Name, refresh.Count, refresh.Err)
+		default:
+			fmt.Printf("  %s: not selected, kept %d codes\n", refresh.Name, refresh.Count)
+		}
+	}
+	fmt.Println()
+
+	if !result.Changed {
+		fmt.Println("Nothing changed, cache file left as is.")
+		return nil
+	}
+
+	// Keep a plain JSON copy next to the compressed cache for reviewing diffs
+	if err := exportToFile(data.Bundled, data.Paths.BundledExportFile()); err != nil {
+		return err
+	}
+
+	fmt.Println("========================================")
+	fmt.Println("  Conversion Complete!")
+	fmt.Println("========================================")
+	fmt.Printf("Cache file: %s\n", data.Bundled.GetCachePath())
+	fmt.Printf("JSON export: %s\n", data.Paths.BundledExportFile())
+	fmt.Printf("Total codes: %d\n", result.TotalCount)
+	fmt.Printf("Version: %s\n", CacheVersion)
+	fmt.Println()
+	fmt.Println("You can now build the application without")
+	fmt.Println("including the Excel files.")
+	fmt.Println("========================================")
+	return nil
+}
+
+// runPaths prints every resolved storage location
+func runPaths(data *DataLayer) error {
+	paths := data.Paths
+	rows := []struct {
+		label string
+		path  string
+	}{
+		{"Config dir", paths.ConfigDir},
+		{"Data dir", paths.DataDir},
+		{"Cache dir", paths.CacheDir},
+		{"Bundled dir", paths.BundledDir},
+		{"Cache file", paths.CacheFile()},
+		{"Bundled file", paths.BundledCacheFile()},
+		{"Bundled JSON", paths.BundledExportFile()},
+		{"Quarantine", paths.QuarantineFile()},
+	}
+
+	fmt.Printf("Resolved from: %s\n", paths.ResolvedFrom)
+	if paths.Portable {
+		fmt.Println("Portable mode: all state is kept next to the executable")
+	}
+	if paths.ReadOnly {
+		fmt.Println("Read-only mode: embedded data is served from memory, nothing is written")
+	}
+	for _, row := range rows {
+		status := "missing"
+		if _, err := os.Stat(row.path); err == nil {
+			status = "exists"
+		}
+		fmt.Printf("%-13s %s (%s)\n", row.label+":", row.path, status)
+	}
+	return nil
+}
+
+// runVerify checks the content hashes of cache files
+// Without arguments it verifies the user cache and the bundled data file.
+func runVerify(data *DataLayer, files []string) error {
+	managers := []*CacheManager{data.Cache, data.Bundled}
+	if len(files) > 0 {
+		managers = nil
+		for _, file := range files {
+			managers = append(managers, NewCacheManager(file))
+		}
+	}
+
+	failed := 0
+	for _, cm := range managers {
+		fmt.Printf("%s:\n", cm.GetCachePath())
+		cache, err := cm.LoadEnvelope()
+		if err != nil {
+			fmt.Printf("  skipped: %v\n", err)
+			continue
+		}
+
+		report := cm.Integrity()
+		fmt.Printf("  %s: %s\n", report.Status, report.Message())
+		for _, meta := range cache.SourceTable() {
+			status := "ok"
+			if meta.ContentHash == "" {
+				status = "no hash"
+			}
+			for _, mismatched := range report.MismatchedSources {
+				if mismatched == meta.Name {
+					status = "MODIFIED"
+				}
+			}
+			fmt.Printf("  source %s: %s\n", meta.Name, status)
+		}
+
+		if report.Status == IntegrityMismatch {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d cache file(s) failed integrity verification", failed)
+	}
+	return nil
+}
+
+// runPack dispatches the data pack subcommands
+func runPack(data *DataLayer, args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing pack subcommand (keygen, sign or verify)")
+	}
+
+	switch args[0] {
+	case "keygen":
+		return runPackKeygen(args[1:])
+	case "sign":
+		return runPackSign(data, args[1:])
+	case "verify":
+		return runPackVerify(data, args[1:])
+	default:
+		printUsage()
+		return fmt.Errorf("unknown pack subcommand: %s", args[0])
+	}
+}
+
+// runPackKeygen creates a signing key and prints its public half
+func runPackKeygen(args []string) error {
+	flags := flag.NewFlagSet("pack keygen", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the private key to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("--out is required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists, refusing to overwrite a key", *out)
+	}
+
+	key, err := GeneratePackKey()
+	if err != nil {
+		return err
+	}
+	if err := WritePrivateKeyFile(*out, key); err != nil {
+		return err
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	fmt.Printf("Private key: %s (keep it secret)\n", *out)
+	fmt.Printf("Public key:  %s\n", EncodeKey(pub))
+	fmt.Printf("Key ID:      %s\n", KeyID(pub))
+	fmt.Printf("Add the public key to %s on clients, or compile it in with\n", TrustedKeysFileName)
+	fmt.Println("  -ldflags \"-X delta-tool/app.TrustedPackKeys=<public key>\"")
+	return nil
+}
+
+// runPackSign signs a cache file as a data pack using a local key
+func runPackSign(data *DataLayer, args []string) error {
+	flags := flag.NewFlagSet("pack sign", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "private key file created by pack keygen")
+	out := flags.String("out", "pack", "directory to write the pack to")
+	version := flags.String("version", "", "data version (default: current UTC time)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return fmt.Errorf("--key is required")
+	}
+
+	key, err := ReadPrivateKeyFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Sign the bundled data unless a cache file is given
+	source := data.Bundled
+	if flags.NArg() > 0 {
+		source = NewCacheManager(flags.Arg(0))
+	}
+	cache, err := source.LoadEnvelope()
+	if err != nil {
+		return err
+	}
+	if report := source.Integrity(); report.Status == IntegrityMismatch {
+		return fmt.Errorf("refusing to sign a modified cache: %s", report.Message())
+	}
+
+	if *version == "" {
+		*version = time.Now().UTC().Format("20060102150405")
+	}
+	payload, err := json.Marshal(APIResponse{
+		Success:     true,
+		Version:     *version,
+		LastUpdated: cache.LastUpdated,
+		Data:        cache.WeaponCodes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pack payload: %w", err)
+	}
+
+	pack, err := SignPack(payload, *version, key)
+	if err != nil {
+		return err
+	}
+	if err := WritePackDir(*out, pack); err != nil {
+		return err
+	}
+
+	fmt.Printf("Signed %d weapon codes as data pack %s\n", len(cache.WeaponCodes), *version)
+	fmt.Printf("Pack directory: %s\n", *out)
+	fmt.Printf("Key ID: %s\n", KeyID(key.Public().(ed25519.PublicKey)))
+	return nil
+}
+
+// runPackVerify verifies a pack directory against the trusted keys
+func runPackVerify(data *DataLayer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delta-tool pack verify DIR")
+	}
+
+	pack, err := ReadPackDir(args[0])
+	if err != nil {
+		return err
+	}
+
+	manifest, err := VerifyPack(pack, data.Loader.config.TrustedKeys)
+	if err != nil {
+		return fmt.Errorf("rejected data pack: %w", err)
+	}
+
+	apiResp, err := DecodePackPayload(pack)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Data pack %s is valid\n", manifest.Version)
+	fmt.Printf("Signed by key %s at %s\n", manifest.KeyID, manifest.CreatedAt)
+	fmt.Printf("Weapon codes: %d\n", len(apiResp.Data))
+	return nil
+}
+
+// runExport writes a cache as plain, indented JSON
+// Without a cache file argument it exports the user cache.
+func runExport(data *DataLayer, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", CacheFileName, "file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	source := data.Cache
+	if flags.NArg() > 0 {
+		source = NewCacheManager(flags.Arg(0))
+	}
+
+	if err := exportToFile(source, *out); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s to %s\n", source.GetCachePath(), *out)
+	return nil
+}
+
+// runFeed writes an Atom feed of the changes between cache snapshots
+func runFeed(args []string) error {
+	flags := flag.NewFlagSet("feed", flag.ContinueOnError)
+	out := flags.String("out", FeedFileName, "file to write")
+	selfURL := flags.String("url", "", "URL the feed will be published at")
+	var filter FetchOptions
+	flags.StringVar(&filter.Weapon, "weapon", "", "only weapons whose name contains this")
+	flags.StringVar(&filter.Source, "source", "", "only this data source")
+	flags.StringVar(&filter.Mode, "mode", "", "only this game mode")
+	flags.StringVar(&filter.Class, "class", "", "only this weapon class")
+	flags.StringVar(&filter.Tier, "tier", "", "only this tier")
+	minPrice := flags.Int("min-price", -1, "only builds costing at least this much (万)")
+	maxPrice := flags.Int("max-price", -1, "only builds costing at most this much (万)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return fmt.Errorf("feed needs at least two cache snapshots, oldest first")
+	}
+	if *minPrice >= 0 {
+		filter.MinPrice = minPrice
+	}
+	if *maxPrice >= 0 {
+		filter.MaxPrice = maxPrice
+	}
+
+	var snapshots []FeedSnapshot
+	for _, path := range flags.Args() {
+		snapshot, err := LoadFeedSnapshot(path)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	body, err := BuildFeed(snapshots, FeedOptions{Filter: filter, SelfURL: *selfURL})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, body, 0644); err != nil {
+		return fmt.Errorf("failed to write feed: %w", err)
+	}
+	fmt.Printf("Wrote feed of %d snapshots to %s\n", len(snapshots), *out)
+	return nil
+}
+
+// runMockAPI serves cache snapshots as a mock API that fails on request,
+// so the client's fallbacks can be tried by hand
+func runMockAPI(data *DataLayer, args []string) error {
+	flags := flag.NewFlagSet("mock-api", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8081", "address to listen on")
+	script := flags.String("script", "", "scenarios for the first requests, one each (e.g. 500,malformed,ok)")
+	scenario := flags.String("scenario", string(ScenarioOK), "scenario once the script has run out")
+	delay := flags.Duration("delay", DefaultMockDelay, "how long the slow scenario waits")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	scripted, err := ParseMockScenarios(*script)
+	if err != nil {
+		return err
+	}
+	fallback, err := ParseMockScenarios(*scenario)
+	if err != nil || len(fallback) != 1 {
+		return fmt.Errorf("--scenario takes one of %s", mockScenarioNames())
+	}
+
+	// Every cache file is a version, oldest first, so deltas can be asked
+	// for; the last one is served as the current version
+	caches := []*CacheManager{data.Cache}
+	if flags.NArg() > 0 {
+		caches = nil
+		for _, path := range flags.Args() {
+			caches = append(caches, NewCacheManager(path))
+		}
+	}
+	noCache := func(cm *CacheManager, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no cache at %s: start the app once or pass a cache file", cm.GetCachePath())
+		}
+		return err
+	}
+	var history []*WeaponCodeCache
+	for _, cm := range caches[:len(caches)-1] {
+		cache, err := cm.readEnvelope()
+		if err != nil {
+			return noCache(cm, err)
+		}
+		history = append(history, cache)
+	}
+	current := caches[len(caches)-1]
+	server := NewCodeServer(current)
+	if err := server.LoadHistory(history); err != nil {
+		return err
+	}
+	if _, err := server.Reload(); err != nil {
+		return noCache(current, err)
+	}
+
+	mock := NewMockAPI(server)
+	mock.Script(scripted...)
+	mock.SetScenario(fallback[0])
+	mock.SetDelay(*delay)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *addr, err)
+	}
+	baseURL := "http://" + listener.Addr().String()
+	fmt.Printf("Mock API listening on %s%s (%d versions)\n", baseURL, WeaponCodesPath, len(caches))
+	fmt.Printf("Point the app at it with %s=%s\n", APIURLEnvVar, baseURL)
+	fmt.Printf("Change the script with: curl -X POST '%s%s?next=500,ok&default=ok&delay=2s'\n", baseURL, MockScriptPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := serveHTTP(ctx, listener, mock.Handler()); err != nil {
+		return err
+	}
+	fmt.Printf("Failed %d requests on purpose\n", mock.Failed())
+	return nil
+}
+
+// runServe serves a cache over HTTP until interrupted
+// Without a cache file argument it serves the user cache.
+func runServe(data *DataLayer, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", DefaultServeAddr, "address to listen on")
+	reload := flags.Duration("reload", 30*time.Second, "how often to pick up cache changes (0 = never)")
+	adminTokenFile := flags.String("admin-token-file", "", "file holding the admin token (default: $"+AdminTokenEnvVar+")")
+	keysFile := flags.String("api-keys", filepath.Join(data.Paths.ConfigDir, APIKeysFileName), "API keys file; when it exists a key is required")
+	rate := flags.Float64("rate", DefaultRateLimit.Rate, "requests per second allowed per API key or IP (0 = no limit)")
+	burst := flags.Int("burst", DefaultRateLimit.Burst, "requests allowed at once per API key or IP")
+	accessLog := flags.String("access-log", "-", "file to append JSON access logs to (- = stdout, off = none)")
+	feedDir := flags.String("feed-dir", filepath.Join(data.Paths.DataDir, FeedHistoryDirName), "directory keeping the snapshots of "+FeedPath+" across restarts (\"\" = memory only)")
+	packDir := flags.String("pack", "", "signed data pack directory (written by pack sign) to serve at "+DataPackPath)
+	publicURL := flags.String("public-url", "", "base URL clients reach the server at, used for the feed's self link (e.g. https://codes.example.com)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *publicURL != "" {
+		if u, err := url.Parse(*publicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--public-url must be an absolute http or https URL")
+		}
+	}
+	adminToken, err := LoadAdminToken(*adminTokenFile)
+	if err != nil {
+		return err
+	}
+	keys, err := LoadAPIKeys(*keysFile)
+	if err != nil {
+		return err
+	}
+
+	source := data.Cache
+	if flags.NArg() > 0 {
+		if source, err = serveSource(data, flags.Arg(0)); err != nil {
+			return err
+		}
+	}
+
+	server := NewCodeServer(source)
+	server.SetPublicURL(*publicURL)
+	if *packDir != "" {
+		if err := server.EnablePack(*packDir); err != nil {
+			return err
+		}
+	}
+	if *feedDir != "" && !data.Paths.ReadOnly {
+		if err := server.EnableFeedHistory(*feedDir); err != nil {
+			return err
+		}
+	}
+	if _, err := server.Reload(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no cache at %s: start the app once or pass a cache file", source.GetCachePath())
+		}
+		return err
+	}
+
+	if len(keys) > 0 {
+		server.EnableAPIKeys(keys)
+		fmt.Printf("API keys required (%d loaded from %s)\n", len(keys), *keysFile)
+	}
+	adminKey := false
+	for _, key := range keys {
+		adminKey = adminKey || key.HasScope(ScopeAdmin)
+	}
+	if adminToken != "" || adminKey {
+		server.EnableAdmin(adminToken)
+		fmt.Printf("Admin uploads enabled at %s\n", AdminUploadsPath)
+	}
+	server.EnableRateLimit(RateLimit{Rate: *rate, Burst: *burst})
+	switch *accessLog {
+	case "off":
+	case "-":
+		server.EnableAccessLog(os.Stdout)
+	default:
+		f, err := os.OpenFile(*accessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		defer f.Close()
+		server.EnableAccessLog(f)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.ListenAndServe(ctx, *addr, *reload)
+}
+
+// serveSource returns the cache manager serve uses for a cache file. In
+// read-only mode the file is copied into memory, so admin publishes never
+// write to disk.
+func serveSource(data *DataLayer, path string) (*CacheManager, error) {
+	if !data.Paths.ReadOnly {
+		return NewCacheManager(path), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no cache at %s", path)
+		}
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+	return NewCacheManagerFromBytes(raw), nil
+}
+
+// exportToFile writes the plain JSON export of a cache to path
+func exportToFile(cm *CacheManager, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if err := cm.ExportJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateCacheRefusesReadOnly(t *testing.T) {
+	paths := testPaths(t)
+	paths.ReadOnly = true
+	if err := RunCommand(newTestDataLayer(t, paths), []string{"generate-cache"}); err == nil {
+		t.Error("generate-cache ran in read-only mode")
+	}
+	if _, err := os.Stat(paths.BundledDir); !os.IsNotExist(err) {
+		t.Errorf("bundled dir touched (err %v)", err)
+	}
+}
+
+// TestReadOnlyServePublishesInMemory publishes through the admin path of a
+// read-only serve and checks the served file is left alone
+func TestReadOnlyServePublishesInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CacheFileName)
+	before := testCacheBytes(t)
+	if err := os.WriteFile(path, before, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := testPaths(t)
+	paths.ReadOnly = true
+	source, err := serveSource(newTestDataLayer(t, paths), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewCodeServer(source)
+	if _, err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Save(testCodes(2), "admin-upload"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := server.Reload(); err != nil || !changed {
+		t.Fatalf("reload: changed %v, err %v", changed, err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Errorf("read-only serve wrote to %s (err %v)", path, err)
+	}
+
+	if _, err := serveSource(newTestDataLayer(t, paths), filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing cache file accepted")
+	}
+}
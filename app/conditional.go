@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrNotModified is returned by conditional fetches when the server answers
+// 304 Not Modified: the cached data is still current.
+var ErrNotModified = errors.New("not modified")
+
+// EndpointMeta holds the HTTP validators of one API endpoint
+type EndpointMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// CheckedAt is when the endpoint was last requested successfully (RFC 3339)
+	CheckedAt string `json:"checked_at,omitempty"`
+	// DataVersion is the API version of the data last received from the
+	// endpoint; it is sent back to ask for a delta
+	DataVersion string `json:"data_version,omitempty"`
+	// Mirror is the base URL of the mirror that last answered
+	Mirror string `json:"mirror,omitempty"`
+	// PackCreatedAt is the creation time of the last signed data pack
+	// accepted from the endpoint (RFC 3339); older packs are replays
+	PackCreatedAt string `json:"pack_created_at,omitempty"`
+}
+
+// getConditional sends a GET request carrying the validators of prev
+// Failed requests are retried according to the client's retry policy and
+// recorded in its circuit breaker. A 304 response is turned into
+// ErrNotModified; any other response is returned for the caller to handle,
+// together with its validators.
+func (api *APIClient) getConditional(ctx context.Context, path string, prev EndpointMeta) (*http.Response, EndpointMeta, error) {
+	if err := api.breaker.Allow(); err != nil {
+		return nil, EndpointMeta{}, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := api.getOnce(ctx, path, prev)
+		if ctx.Err() != nil {
+			// Cancelled by the caller, say nothing about the endpoint's health
+			api.breaker.Release()
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, EndpointMeta{}, ctx.Err()
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			delay = api.retry.backoff(attempt - 1)
+		case retryableStatus(resp.StatusCode):
+			err = fmt.Errorf("API returned status %d", resp.StatusCode)
+			delay = api.retry.backoff(attempt - 1)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > api.retry.MaxRetryAfter {
+					attempt = api.retry.MaxAttempts // too long to wait, give up now
+				}
+				delay = retryAfter
+			}
+		default:
+			api.breaker.Success()
+			return api.conditionalResult(resp, prev)
+		}
+
+		if attempt >= api.retry.MaxAttempts {
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				// Rate limited: the endpoint is healthy, we asked too often
+				api.breaker.Release()
+			} else {
+				api.breaker.Failure(err)
+			}
+			if resp != nil {
+				// Let the caller report the final status and body
+				return resp, EndpointMeta{}, nil
+			}
+			return nil, EndpointMeta{}, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		fmt.Printf("Request to %s failed (%v), retrying in %s (attempt %d/%d)\n",
+			path, err, delay.Round(time.Millisecond), attempt+1, api.retry.MaxAttempts)
+		if err := sleepContext(ctx, delay); err != nil {
+			api.breaker.Release()
+			return nil, EndpointMeta{}, err
+		}
+	}
+}
+
+// getOnce sends a single conditional GET request
+func (api *APIClient) getOnce(ctx context.Context, path string, prev EndpointMeta) (*http.Response, error) {
+	url := fmt.Sprintf("%s%s", api.baseURL, path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if api.apiKey != "" {
+		req.Header.Set(APIKeyHeader, api.apiKey)
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	resp, err := api.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from API: %w", err)
+	}
+	return resp, nil
+}
+
+// conditionalResult reads the validators of a response and turns a 304
+// into ErrNotModified
+func (api *APIClient) conditionalResult(resp *http.Response, prev EndpointMeta) (*http.Response, EndpointMeta, error) {
+	meta := EndpointMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    formatCacheTime(time.Now()),
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		// A 304 may omit the validators; keep the ones we sent
+		if meta.ETag == "" {
+			meta.ETag = prev.ETag
+		}
+		if meta.LastModified == "" {
+			meta.LastModified = prev.LastModified
+		}
+		meta.DataVersion = prev.DataVersion
+		meta.PackCreatedAt = prev.PackCreatedAt
+		return nil, meta, ErrNotModified
+	}
+	return resp, meta, nil
+}
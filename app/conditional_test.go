@@ -0,0 +1,81 @@
+package app_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// newLoader returns a loader with a cache in a temporary directory that
+// fetches from baseURL
+func newLoader(t *testing.T, baseURL string, config app.DataSourceConfig) (*app.WeaponCodeLoader, *app.CacheManager) {
+	t.Helper()
+	cm := app.NewCacheManager(filepath.Join(t.TempDir(), app.CompressedCacheFileName))
+	config.UseLocalCache = true
+	config.APIBaseURL = baseURL
+	if config.CacheMaxAge == 0 {
+		config.CacheMaxAge = time.Hour
+	}
+	return app.NewWeaponCodeLoaderWithConfig(cm, config), cm
+}
+
+// TestConditionalFetch checks that unchanged data is answered with 304 and
+// only bumps the check time, while changed data is downloaded again
+func TestConditionalFetch(t *testing.T) {
+	server := apitest.NewServer(t, apitest.Codes(1))
+	loader, cm := newLoader(t, server.URL, app.DataSourceConfig{})
+
+	// refresh runs one refresh and checks whether it downloaded the codes
+	refresh := func(step string, wantChanged bool, wantNotModified int) {
+		t.Helper()
+		codes, changed, err := loader.Refresh(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if changed != wantChanged {
+			t.Fatalf("%s: changed = %v, want %v", step, changed, wantChanged)
+		}
+		if len(codes) == 0 {
+			t.Fatalf("%s: no codes returned", step)
+		}
+		if notModified := server.Stats().NotModified; notModified != wantNotModified {
+			t.Fatalf("%s: server sent %d 304 responses, want %d", step, notModified, wantNotModified)
+		}
+	}
+
+	refresh("first fetch", true, 0)
+	if cm.EndpointValidators(app.WeaponCodesPath).ETag == "" {
+		t.Fatal("first fetch: ETag not stored in the cache")
+	}
+	before, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the check time can move forward at RFC 3339 precision
+	time.Sleep(1100 * time.Millisecond)
+	refresh("unchanged (ETag)", false, 1)
+	after, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.LastUpdated != before.LastUpdated || after.ContentHash != before.ContentHash {
+		t.Fatal("unchanged (ETag): 304 rewrote the cached codes")
+	}
+	if after.CheckedAt == before.CheckedAt {
+		t.Fatal("unchanged (ETag): check time not bumped")
+	}
+
+	server.SetCodes(apitest.Codes(2))
+	refresh("changed (ETag)", true, 1)
+
+	// Without an ETag the client has to rely on Last-Modified
+	server.SetSendETag(false)
+	server.SetCodes(apitest.Codes(3))
+	refresh("changed (Last-Modified)", true, 1)
+	refresh("unchanged (Last-Modified)", false, 2)
+}
@@ -0,0 +1,436 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiContract checks requests and responses against an OpenAPI document
+// as served at OpenAPIPath. It understands the subset of JSON Schema that
+// OpenAPIDocument produces.
+type apiContract struct {
+	doc     map[string]any
+	schemas map[string]any
+}
+
+// newAPIContract parses an OpenAPI document
+func newAPIContract(data []byte) (*apiContract, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	if schemas == nil {
+		return nil, fmt.Errorf("OpenAPI document has no component schemas")
+	}
+	return &apiContract{doc: doc, schemas: schemas}, nil
+}
+
+// operation returns the operation documented for method on path
+func (c *apiContract) operation(method, path string) (map[string]any, error) {
+	paths, _ := c.doc["paths"].(map[string]any)
+	item, _ := paths[path].(map[string]any)
+	op, _ := item[strings.ToLower(method)].(map[string]any)
+	if op == nil {
+		return nil, fmt.Errorf("%s %s is not documented", method, path)
+	}
+	return op, nil
+}
+
+// parameters returns the names of the documented parameters of an
+// operation that are passed in (query, header or path)
+func (c *apiContract) parameters(method, path, in string) (map[string]bool, error) {
+	op, err := c.operation(method, path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	params, _ := op["parameters"].([]any)
+	for _, p := range params {
+		param, _ := p.(map[string]any)
+		if param["in"] == in {
+			names[fmt.Sprint(param["name"])] = true
+		}
+	}
+	return names, nil
+}
+
+// transportHeaders are sent by net/http or describe the transfer rather
+// than the protocol, so the document does not list them
+var transportHeaders = map[string]bool{
+	"Accept":          true,
+	"Accept-Encoding": true,
+	"Authorization":   true,
+	"Connection":      true,
+	"Content-Length":  true,
+	"Content-Type":    true,
+	"User-Agent":      true,
+}
+
+// checkRequest reports the query parameters and headers of a request that
+// the document does not declare for it
+func (c *apiContract) checkRequest(r *http.Request) []string {
+	where := r.Method + " " + r.URL.Path
+	queries, err := c.parameters(r.Method, r.URL.Path, "query")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	headers, _ := c.parameters(r.Method, r.URL.Path, "header")
+	for name := range headers {
+		headers[http.CanonicalHeaderKey(name)] = true
+	}
+	components, _ := c.doc["components"].(map[string]any)
+	schemes, _ := components["securitySchemes"].(map[string]any)
+	for _, s := range schemes {
+		scheme, _ := s.(map[string]any)
+		if scheme["in"] == "header" {
+			headers[http.CanonicalHeaderKey(fmt.Sprint(scheme["name"]))] = true
+		}
+	}
+
+	var problems []string
+	for name := range r.URL.Query() {
+		if !queries[name] {
+			problems = append(problems, fmt.Sprintf("%s: query parameter %q is not documented", where, name))
+		}
+	}
+	for name := range r.Header {
+		if !headers[name] && !transportHeaders[name] {
+			problems = append(problems, fmt.Sprintf("%s: header %q is not documented", where, name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkResponse reports how a response deviates from the documented one
+// mediaType selects the documented content; body is decoded as JSON (for
+// an event stream, pass the data of one event).
+func (c *apiContract) checkResponse(method, path string, status int, mediaType string, body []byte) []string {
+	op, err := c.operation(method, path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	where := fmt.Sprintf("%s %s %d", method, path, status)
+	responses, _ := op["responses"].(map[string]any)
+	response, _ := responses[strconv.Itoa(status)].(map[string]any)
+	if response == nil {
+		return []string{where + ": status is not documented"}
+	}
+
+	content, _ := response["content"].(map[string]any)
+	if content == nil {
+		if len(body) > 0 {
+			return []string{where + ": documented without a body but has one"}
+		}
+		return nil
+	}
+	media, _ := content[mediaType].(map[string]any)
+	if media == nil {
+		return []string{fmt.Sprintf("%s: content type %s is not documented", where, mediaType)}
+	}
+	schema, _ := media["schema"].(map[string]any)
+
+	// Plain text bodies are checked as a single string, everything else
+	// (including event data) as JSON
+	var value any = string(body)
+	if mediaType != "text/plain" {
+		if err := json.Unmarshal(body, &value); err != nil {
+			return []string{fmt.Sprintf("%s: body is not JSON: %v", where, err)}
+		}
+	}
+	return c.validate(schema, value, where)
+}
+
+// validate checks a decoded JSON value against schema
+func (c *apiContract) validate(schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, _ := c.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+		if target == nil {
+			return []string{fmt.Sprintf("%s: unknown schema %s", at, ref)}
+		}
+		return c.validate(target, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": null is not allowed"}
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		var problems []string
+		for _, sub := range all {
+			subSchema, _ := sub.(map[string]any)
+			problems = append(problems, c.validate(subSchema, value, at)...)
+		}
+		return problems
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want an object, got %T", at, value)}
+		}
+		return c.validateObject(schema, object, at)
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want an array, got %T", at, value)}
+		}
+		items, _ := schema["items"].(map[string]any)
+		var problems []string
+		for i, item := range array {
+			problems = append(problems, c.validate(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []string{fmt.Sprintf("%s: want a string, got %T", at, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: want a boolean, got %T", at, value)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s: want a number, got %T", at, value)}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s: want an integer, got %v", at, value)}
+		}
+	}
+	return nil
+}
+
+// validateObject checks the properties of a decoded JSON object
+func (c *apiContract) validateObject(schema, object map[string]any, at string) []string {
+	var problems []string
+	properties, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		if _, ok := object[fmt.Sprint(name)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing required %q", at, name))
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := properties[name].(map[string]any)
+		if !ok {
+			if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, c.validate(additional, object[name], at+"."+name)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s: undocumented property %q", at, name))
+			}
+			continue
+		}
+		problems = append(problems, c.validate(property, object[name], at+"."+name)...)
+	}
+	return problems
+}
+
+// contractMediaType returns the media type of a response without parameters
+func contractMediaType(resp *http.Response) string {
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return strings.TrimSpace(mediaType)
+}
+
+// fetchContract reads the OpenAPI document served at baseURL
+func fetchContract(t *testing.T, baseURL string) *apiContract {
+	t.Helper()
+	resp, err := http.Get(baseURL + OpenAPIPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, err := newAPIContract(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contract
+}
+
+// checkContractResponse sends a request and checks the response against
+// the contract; documented is the templated path of the operation
+func checkContractResponse(t *testing.T, contract *apiContract, baseURL, method, documented, target string, header http.Header, body []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, baseURL+target, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range contract.checkResponse(method, documented, resp.StatusCode, contractMediaType(resp), data) {
+		t.Errorf("%s %s: %s", method, target, problem)
+	}
+}
+
+// TestContract fetches the OpenAPI document of a CodeServer and checks
+// that its responses match the schemas, and that every request the client
+// sends only uses what the document declares
+func TestContract(t *testing.T) {
+	server, served := newTestCodeServer(t, testCodes(1))
+	v1, _ := server.snapshot()
+	if err := served.Save(testCodes(2), "local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	const token = "test-contract-token"
+	server.EnableAdmin(token)
+
+	// Every request is recorded so the client's can be checked afterwards
+	var mu sync.Mutex
+	var requests []*http.Request
+	handler := server.Handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Clone(context.Background()))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	contract := fetchContract(t, ts.URL)
+	check := func(method, documented, target string, header http.Header, body []byte) {
+		t.Helper()
+		checkContractResponse(t, contract, ts.URL, method, documented, target, header, body)
+	}
+
+	current, _ := server.snapshot()
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath, nil, nil)
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath+"?"+SinceParam+"="+v1.version, nil, nil)
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath+"?"+SinceParam+"=unknown", nil, nil)
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath+"?"+FetchOptions{Source: "刀仔", Limit: 1}.Values().Encode(), nil, nil)
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath+"?"+LimitParam+"=many", nil, nil)
+	check(http.MethodGet, WeaponCodesPath, WeaponCodesPath, http.Header{"If-None-Match": {`"` + current.version + `"`}}, nil)
+	check(http.MethodGet, HealthPath, HealthPath, nil, nil)
+	check(http.MethodGet, DataPackPath+PackManifestFile, DataPackPath+PackManifestFile, nil, nil)
+
+	// Data pack files
+	key, _ := testKey(t)
+	packDir := t.TempDir()
+	if err := WritePackDir(packDir, testPack(t, testCodes(2), key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.EnablePack(packDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{PackManifestFile, PackPayloadFile, PackSignatureFile} {
+		check(http.MethodGet, DataPackPath+name, DataPackPath+name, nil, nil)
+	}
+
+	// Admin endpoints
+	workbook := testWorkbook(t, [][]string{
+		{"M4A1", "T0", "30w", "满配", testCode(201), "50米", "10.5"},
+		{"MP7", "T1", "20w", "腰射", testCode(202), "20米", "10.6"},
+	})
+	admin := http.Header{"Authorization": {"Bearer " + token}}
+	uploadPath := AdminUploadsPath + "?" + url.Values{SourceParam: {"刀仔"}}.Encode()
+	check(http.MethodPost, AdminUploadsPath, uploadPath, nil, workbook)
+	check(http.MethodPost, AdminUploadsPath, uploadPath, admin, []byte("not a workbook"))
+	check(http.MethodPost, AdminUploadsPath, uploadPath, admin, workbook)
+	server.admin.mu.Lock()
+	var uploadID string
+	for id := range server.admin.uploads {
+		uploadID = id
+	}
+	server.admin.mu.Unlock()
+	check(http.MethodPost, AdminUploadsPath+"/{id}/publish", AdminUploadsPath+"/"+uploadID+"/publish", admin, nil)
+	check(http.MethodPost, AdminUploadsPath+"/{id}/publish", AdminUploadsPath+"/"+uploadID+"/publish", admin, nil)
+	check(http.MethodDelete, AdminUploadsPath+"/{id}", AdminUploadsPath+"/none", admin, nil)
+
+	// The client's requests, including a fully populated FetchOptions
+	mu.Lock()
+	requests = nil
+	mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewAPIClientWithPolicy(ts.URL, RetryPolicy{MaxAttempts: 1}, BreakerConfig{})
+	client.SetAPIKey(token)
+	full, meta, err := client.FetchWeaponCodesConditional(ctx, EndpointMeta{})
+	if err != nil {
+		t.Fatalf("client full fetch: %v", err)
+	}
+	if _, _, err := client.FetchWeaponCodesConditional(ctx, EndpointMeta{ETag: meta.ETag, LastModified: meta.LastModified, DataVersion: full.Version}); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("client conditional fetch: %v", err)
+	}
+	if _, _, err := client.FetchDataPackConditional(ctx, EndpointMeta{}); err != nil {
+		t.Fatalf("client pack fetch: %v", err)
+	}
+	price := 1
+	everything := FetchOptions{
+		Mode: "烽火地带", Source: "刀仔", Weapon: "M4", Class: "突击步枪", Tier: "T0",
+		MinPrice: &price, MaxPrice: &price, UpdatedSince: time.Now(), Cursor: encodeCursor(codeSetVersion(full.Data), 1), Limit: 1,
+	}
+	// No entry matches all of these, so the cursor is past the last match
+	// and refused; only the
+	// request matters here
+	client.FetchWeaponCodesWithOptions(ctx, everything)
+	events := make(chan VersionEvent, 1)
+	streamCtx, stopStream := context.WithCancel(ctx)
+	go client.Subscribe(streamCtx, v1.version, func(event VersionEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	})
+	select {
+	case event := <-events:
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range contract.checkResponse(http.MethodGet, EventsPath, http.StatusOK, "text/event-stream", data) {
+			t.Errorf("event: %s", problem)
+		}
+	case <-ctx.Done():
+		t.Error("client: no event from the stream")
+	}
+	stopStream()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range requests {
+		for _, problem := range contract.checkRequest(r) {
+			t.Errorf("client request %s: %s", r.URL, problem)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// APIURLEnvVar enables fetching weapon codes from the data service at this
+// URL; several comma separated URLs are tried as mirrors in that order
+const APIURLEnvVar = "DELTA_TOOL_API_URL"
+
+// DataLayer holds the storage objects shared by the GUI, the CLI commands
+// and the weapon code loader. It is built once at startup and injected
+// everywhere, so every consumer reads the same cache file.
+type DataLayer struct {
+	Paths StoragePaths
+	// Cache is the writable weapon codes cache in the user data directory
+	Cache *CacheManager
+	// Bundled is the read-only weapon codes file shipped with the app.
+	// Only the generate-cache development command writes to it.
+	Bundled *CacheManager
+	Loader  *WeaponCodeLoader
+}
+
+// NewDataLayer creates the shared data layer for the resolved storage paths
+// It fails when trusted data pack keys are configured but cannot be loaded,
+// rather than falling back to accepting unsigned data.
+func NewDataLayer(paths StoragePaths) (*DataLayer, error) {
+	cacheManager := NewCacheManager(paths.CacheFile())
+	bundled := NewCacheManager(paths.BundledCacheFile())
+	if paths.ReadOnly {
+		// Nothing may be written, not even by CLI commands; the cache stays
+		// empty until InitializeFromEmbedded fills it, and the bundled data
+		// is read through a read-only filesystem
+		cacheManager = NewCacheManagerFromBytes(nil)
+		bundled = NewCacheManagerFromFS(os.DirFS(paths.BundledDir), CompressedCacheFileName)
+	} else {
+		for _, legacyPath := range paths.PreviousCacheFiles() {
+			// On Windows the old cache sits in the install directory, which
+			// may also hold the bundled data; nothing there is removed
+			keep := isInDir(legacyPath, paths.BundledDir)
+			if err := cacheManager.MigratePlainCache(legacyPath, keep); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+
+	// Data pack keys from the binary and the config directory
+	trustedKeys, err := LoadTrustedKeys(paths.ConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted keys: %w", err)
+	}
+
+	// API mirrors from the environment and the config directory
+	mirrors, err := LoadMirrors(os.Getenv(APIURLEnvVar), os.Getenv(APIKeyEnvVar), paths.ConfigDir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load API mirrors: %v\n", err)
+	}
+
+	loader := NewWeaponCodeLoaderWithConfig(cacheManager, DataSourceConfig{
+		UseLocalCache: true,
+		Mirrors:       mirrors,
+		CacheMaxAge:   24 * time.Hour,
+		TrustedKeys:   trustedKeys,
+	})
+	if !paths.ReadOnly {
+		loader.config.QuarantinePath = paths.QuarantineFile()
+	}
+
+	return &DataLayer{
+		Paths:   paths,
+		Cache:   cacheManager,
+		Bundled: bundled,
+		Loader:  loader,
+	}, nil
+}
+
+// InitializeFromEmbedded prepares the shared cache from embedded data.
+// It must be called before the GUI or a CLI command reads any weapon codes.
+// In read-only mode, or when the cache file cannot be written (locked-down
+// machines), the embedded data is served directly from memory instead.
+func (d *DataLayer) InitializeFromEmbedded(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("no embedded data provided")
+	}
+	if d.Paths.ReadOnly {
+		d.Cache.UseEmbedded(data)
+		return nil
+	}
+
+	if err := d.Cache.InitializeFromEmbedded(data); err != nil {
+		d.Cache.UseEmbedded(data)
+		return fmt.Errorf("%w (serving embedded data from memory instead)", err)
+	}
+	return nil
+}
+
+// InitializeFromBundled prepares the shared cache like InitializeFromEmbedded,
+// reading the data from the bundled directory instead. Entry points that
+// cannot embed the data file, such as cmd/, call it so that read-only mode
+// has the shipped codes to serve.
+func (d *DataLayer) InitializeFromBundled() error {
+	data, err := os.ReadFile(d.Paths.BundledCacheFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = os.ReadFile(d.Paths.BundledExportFile())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read bundled data: %w", err)
+	}
+	return d.InitializeFromEmbedded(data)
+}
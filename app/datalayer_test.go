@@ -0,0 +1,290 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testCode returns a well-formed weapon code
+func testCode(n int) string {
+	return fmt.Sprintf("6GOTEST%014d", n)
+}
+
+// testCodes returns a small, fixed set of weapon codes; each version
+// changes every code
+func testCodes(version int) []WeaponCode {
+	return []WeaponCode{
+		{ID: "1", Mode: "烽火地带", Name: "M4A1", Tier: "T0", Code: testCode(version*10 + 1), Source: "刀仔"},
+		{ID: "2", Mode: "全面战场", Name: "AK-47", Tier: "T1", Code: testCode(version*10 + 2), Source: "武器大师"},
+		{ID: "3", Mode: "烽火地带", Name: "M14", Tier: "T1", Code: testCode(version*10 + 3), Source: "刀仔"},
+	}
+}
+
+// testCacheBytes returns the cache file contents for testCodes(1), as
+// embedded in the binary
+func testCacheBytes(t *testing.T) []byte {
+	t.Helper()
+	data, err := json.Marshal(WeaponCodeCache{
+		Version:     CacheVersion,
+		LastUpdated: formatCacheTime(time.Now()),
+		TotalCount:  len(testCodes(1)),
+		DataSource:  "local",
+		WeaponCodes: testCodes(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testPaths returns storage paths rooted in a throwaway directory
+func testPaths(t *testing.T) StoragePaths {
+	t.Helper()
+	dir := t.TempDir()
+	return StoragePaths{
+		ConfigDir:    filepath.Join(dir, "config"),
+		DataDir:      filepath.Join(dir, "data"),
+		CacheDir:     filepath.Join(dir, "cache"),
+		BundledDir:   filepath.Join(dir, "bundled"),
+		ResolvedFrom: "flag",
+	}
+}
+
+// newTestDataLayer builds the data layer for paths
+func newTestDataLayer(t *testing.T, paths StoragePaths) *DataLayer {
+	t.Helper()
+	data, err := NewDataLayer(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestNewDataLayerSharesOneCache(t *testing.T) {
+	paths := testPaths(t)
+	data := newTestDataLayer(t, paths)
+
+	if data.Cache.GetCachePath() != paths.CacheFile() {
+		t.Errorf("cache at %s, want %s", data.Cache.GetCachePath(), paths.CacheFile())
+	}
+	if data.Bundled.GetCachePath() != paths.BundledCacheFile() {
+		t.Errorf("bundled data at %s, want %s", data.Bundled.GetCachePath(), paths.BundledCacheFile())
+	}
+	if data.Loader.cacheManager != data.Cache {
+		t.Fatal("loader does not use the shared cache")
+	}
+
+	// What the loader saves is what every other consumer reads
+	if err := data.Loader.cacheManager.Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+	codes, found, err := NewCacheManager(paths.CacheFile()).Load()
+	if err != nil || !found || len(codes) != len(testCodes(1)) {
+		t.Fatalf("shared cache: %d codes, found %v, err %v", len(codes), found, err)
+	}
+}
+
+func TestDataLayerInitializeFromEmbedded(t *testing.T) {
+	embedded := testCacheBytes(t)
+
+	data := newTestDataLayer(t, testPaths(t))
+	if err := data.InitializeFromEmbedded(embedded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(data.Cache.GetCachePath()); err != nil {
+		t.Fatalf("embedded data not extracted: %v", err)
+	}
+	codes, found, err := data.Cache.Load()
+	if err != nil || !found || len(codes) != len(testCodes(1)) {
+		t.Errorf("extracted cache: %d codes, found %v, err %v", len(codes), found, err)
+	}
+	if kind := data.Cache.StoreKind(); kind != StoreKindFile {
+		t.Errorf("store = %s, want %s", kind, StoreKindFile)
+	}
+	// Missing embedded data leaves the extracted cache in place
+	if err := data.InitializeFromEmbedded(nil); err == nil {
+		t.Error("empty embedded data accepted")
+	}
+	if kind := data.Cache.StoreKind(); kind != StoreKindFile {
+		t.Errorf("store = %s after empty embedded data, want %s", kind, StoreKindFile)
+	}
+}
+
+func TestReadOnlyDataLayerNeverWrites(t *testing.T) {
+	paths := testPaths(t)
+	paths.ReadOnly = true
+	data := newTestDataLayer(t, paths)
+
+	// Commands run before InitializeFromEmbedded must not reach the disk
+	if kind := data.Cache.StoreKind(); kind != StoreKindMemory {
+		t.Fatalf("store = %s before InitializeFromEmbedded, want %s", kind, StoreKindMemory)
+	}
+	if data.Loader.config.QuarantinePath != "" {
+		t.Errorf("quarantine at %s in read-only mode", data.Loader.config.QuarantinePath)
+	}
+
+	if err := data.InitializeFromEmbedded(testCacheBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+	if kind := data.Cache.StoreKind(); kind != StoreKindMemory {
+		t.Fatalf("store = %s, want %s", kind, StoreKindMemory)
+	}
+	if err := data.Cache.Save(testCodes(2), "api"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(filepath.Dir(paths.DataDir)); err != nil || len(entries) != 0 {
+		t.Errorf("read-only data layer wrote %v (err %v)", entries, err)
+	}
+}
+
+// TestReadOnlyBundledData reads the bundled data through the read-only
+// fs store, which refuses every write
+func TestReadOnlyBundledData(t *testing.T) {
+	paths := testPaths(t)
+	paths.ReadOnly = true
+	if err := os.MkdirAll(paths.BundledDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths.BundledCacheFile(), testCacheBytes(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := newTestDataLayer(t, paths)
+
+	if kind := data.Bundled.StoreKind(); kind != StoreKindFS {
+		t.Fatalf("bundled store = %s, want %s", kind, StoreKindFS)
+	}
+	if codes, _, err := data.Bundled.Load(); err != nil || len(codes) != len(testCodes(1)) {
+		t.Fatalf("bundled data: %d codes, err %v", len(codes), err)
+	}
+	if err := data.Bundled.Save(testCodes(2), "local"); !errors.Is(err, ErrReadOnlyStore) {
+		t.Errorf("save bundled data: %v, want %v", err, ErrReadOnlyStore)
+	}
+	if codes, _, err := NewCacheManager(paths.BundledCacheFile()).Load(); err != nil || codes[0].Code != testCodes(1)[0].Code {
+		t.Errorf("bundled file changed (err %v)", err)
+	}
+}
+
+// TestInitializeFromBundled seeds a read-only cache from the bundled file,
+// as cmd/main.go does without embedded data
+func TestInitializeFromBundled(t *testing.T) {
+	paths := testPaths(t)
+	paths.ReadOnly = true
+	data := newTestDataLayer(t, paths)
+	if err := data.InitializeFromBundled(); err == nil {
+		t.Error("missing bundled data not reported")
+	}
+
+	if err := os.MkdirAll(paths.BundledDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths.BundledCacheFile(), testCacheBytes(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.InitializeFromBundled(); err != nil {
+		t.Fatal(err)
+	}
+	if codes, _, err := data.Cache.Load(); err != nil || len(codes) != len(testCodes(1)) {
+		t.Errorf("bundled data: %d codes, err %v", len(codes), err)
+	}
+}
+
+func TestDataLayerFallsBackToMemory(t *testing.T) {
+	paths := testPaths(t)
+	// A file where the data directory should be makes the cache unwritable
+	if err := os.WriteFile(paths.DataDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := newTestDataLayer(t, paths)
+
+	if err := data.InitializeFromEmbedded(testCacheBytes(t)); err == nil {
+		t.Error("unwritable cache not reported")
+	}
+	if kind := data.Cache.StoreKind(); kind != StoreKindMemory {
+		t.Errorf("store = %s, want %s", kind, StoreKindMemory)
+	}
+	if codes, _, err := data.Cache.Load(); err != nil || len(codes) != len(testCodes(1)) {
+		t.Errorf("embedded data: %d codes, err %v", len(codes), err)
+	}
+}
+
+func TestNewDataLayerKeepsBundledFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("earlier releases kept the cache in the install directory on Windows")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(home)
+	paths := testPaths(t)
+	paths.ResolvedFrom = "platform"
+
+	// The old location doubles as the bundled data directory, given
+	// relative to the working directory like findBundledDir does
+	previous := paths.PreviousCacheFiles()[0]
+	paths.BundledDir, _ = filepath.Rel(home, filepath.Dir(previous))
+	if err := NewCacheManager(previous).Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		data := newTestDataLayer(t, paths)
+		if _, err := os.Stat(previous); err != nil {
+			t.Fatalf("run %d: file in the bundled directory removed: %v", run, err)
+		}
+		if codes, _, err := data.Cache.Load(); err != nil || len(codes) != len(testCodes(1)) {
+			t.Errorf("run %d: migrated cache: %d codes, err %v", run, len(codes), err)
+		}
+	}
+}
+
+func TestNewDataLayerMigratesPreviousRelease(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("earlier releases kept the cache in the install directory on Windows")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	paths := testPaths(t)
+	paths.ResolvedFrom = "platform"
+
+	previous := paths.PreviousCacheFiles()
+	want := filepath.Join(home, ".config", AppDirName, CacheFileName)
+	if runtime.GOOS == "darwin" {
+		want = filepath.Join(home, "Library", "Application Support", AppDirName, CacheFileName)
+	}
+	if len(previous) != 1 || previous[0] != want {
+		t.Fatalf("previous cache files %v, want %s", previous, want)
+	}
+	if err := NewCacheManager(want).Save(testCodes(2), "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An explicit data directory leaves the old cache alone
+	paths.ResolvedFrom = "env"
+	newTestDataLayer(t, paths)
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("previous cache moved for an explicit data directory: %v", err)
+	}
+
+	paths.ResolvedFrom = "platform"
+	data := newTestDataLayer(t, paths)
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Errorf("previous cache left behind (err %v)", err)
+	}
+	codes, _, err := data.Cache.Load()
+	if err != nil || hashCodeSet(codes) != hashCodeSet(testCodes(2)) {
+		t.Errorf("previous cache not migrated (err %v)", err)
+	}
+
+	// Extracting the embedded data afterwards keeps the migrated cache
+	if err := data.InitializeFromEmbedded(testCacheBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+	if codes, _, _ := data.Cache.Load(); hashCodeSet(codes) != hashCodeSet(testCodes(2)) {
+		t.Error("migrated cache replaced by the embedded data")
+	}
+}
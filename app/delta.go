@@ -0,0 +1,191 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// SinceParam asks the weapon codes endpoint for a delta against this version
+const SinceParam = "since"
+
+// ErrDeltaChainBroken is returned when a delta cannot be applied to the
+// local codes; the caller should fall back to a full fetch.
+var ErrDeltaChainBroken = errors.New("delta chain broken")
+
+// CodeDelta is the change set between two versions of the weapon codes,
+// keyed by stable ID (see stableID)
+type CodeDelta struct {
+	// BaseVersion is the version the delta applies to
+	BaseVersion string       `json:"base_version"`
+	Added       []WeaponCode `json:"added,omitempty"`
+	Changed     []WeaponCode `json:"changed,omitempty"`
+	Removed     []string     `json:"removed,omitempty"`
+	// ResultHash is the hashCodeSet of the codes after applying the delta
+	ResultHash string `json:"result_hash"`
+}
+
+// stableID derives an ID for a weapon code that does not change when rows
+// are reordered: it hashes the source, mode, weapon name and build
+func stableID(code WeaponCode) string {
+	sum := sha256.Sum256([]byte(code.Source + "\x00" + code.Mode + "\x00" + code.Name + "\x00" + code.Build))
+	return hex.EncodeToString(sum[:8])
+}
+
+// assignStableIDs replaces the IDs of codes with stable IDs
+// Identical builds within a source get a numbered suffix.
+func assignStableIDs(codes []WeaponCode) {
+	seen := make(map[string]int)
+	for i := range codes {
+		id := stableID(codes[i])
+		seen[id]++
+		if n := seen[id]; n > 1 {
+			id = fmt.Sprintf("%s-%d", id, n)
+		}
+		codes[i].ID = id
+	}
+}
+
+// migrateLegacyIDs gives the entries of a legacy cache stable IDs
+// Caches written before stable IDs (version 1.0.0) numbered the rows of
+// each source, so the same IDs appear under several sources. Hashes that
+// matched before are recomputed so the migrated cache still verifies.
+// It reports whether any ID was replaced.
+func migrateLegacyIDs(cache *WeaponCodeCache) bool {
+	if cache.Version != legacyCacheVersion && !hasDuplicateIDs(cache.WeaponCodes) {
+		return false
+	}
+
+	verified := verifyCacheIntegrity(cache).Status == IntegrityVerified
+	before := make([]string, len(cache.WeaponCodes))
+	for i, code := range cache.WeaponCodes {
+		before[i] = code.ID
+	}
+	assignStableIDs(cache.WeaponCodes)
+
+	changed := false
+	for i, code := range cache.WeaponCodes {
+		if code.ID != before[i] {
+			changed = true
+			break
+		}
+	}
+	if changed && verified {
+		cache.ContentHash = hashWeaponCodes(cache.WeaponCodes)
+		sourceHashes := hashSources(cache.WeaponCodes)
+		for i := range cache.Sources {
+			if cache.Sources[i].ContentHash != "" {
+				cache.Sources[i].ContentHash = sourceHashes[cache.Sources[i].Name]
+			}
+		}
+	}
+	return changed
+}
+
+// hasDuplicateIDs reports whether two entries share an ID
+func hasDuplicateIDs(codes []WeaponCode) bool {
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if seen[code.ID] {
+			return true
+		}
+		seen[code.ID] = true
+	}
+	return false
+}
+
+// hashCodeSet returns a content hash of codes that ignores their order
+func hashCodeSet(codes []WeaponCode) string {
+	sorted := make([]WeaponCode, len(codes))
+	copy(sorted, codes)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return hashWeaponCodes(sorted)
+}
+
+// DiffCodes returns the delta that turns oldCodes (at baseVersion) into newCodes
+func DiffCodes(oldCodes, newCodes []WeaponCode, baseVersion string) *CodeDelta {
+	delta := &CodeDelta{
+		BaseVersion: baseVersion,
+		ResultHash:  hashCodeSet(newCodes),
+	}
+
+	oldByID := make(map[string]WeaponCode, len(oldCodes))
+	for _, code := range oldCodes {
+		oldByID[code.ID] = code
+	}
+	newIDs := make(map[string]bool, len(newCodes))
+	for _, code := range newCodes {
+		newIDs[code.ID] = true
+		old, ok := oldByID[code.ID]
+		switch {
+		case !ok:
+			delta.Added = append(delta.Added, code)
+		case !reflect.DeepEqual(old, code):
+			delta.Changed = append(delta.Changed, code)
+		}
+	}
+	for _, code := range oldCodes {
+		if !newIDs[code.ID] {
+			delta.Removed = append(delta.Removed, code.ID)
+		}
+	}
+	return delta
+}
+
+// ApplyDelta applies a delta to codes, which must be at version baseVersion
+// Changed entries are replaced in place and added entries are appended.
+// Any inconsistency is reported as ErrDeltaChainBroken.
+func ApplyDelta(codes []WeaponCode, baseVersion string, delta *CodeDelta) ([]WeaponCode, error) {
+	if delta.BaseVersion != baseVersion {
+		return nil, fmt.Errorf("%w: delta is against version %q, local codes are at %q",
+			ErrDeltaChainBroken, delta.BaseVersion, baseVersion)
+	}
+
+	index := make(map[string]int, len(codes))
+	for i, code := range codes {
+		if _, dup := index[code.ID]; dup {
+			return nil, fmt.Errorf("%w: duplicate local ID %q", ErrDeltaChainBroken, code.ID)
+		}
+		index[code.ID] = i
+	}
+
+	result := make([]WeaponCode, len(codes))
+	copy(result, codes)
+	for _, code := range delta.Changed {
+		i, ok := index[code.ID]
+		if !ok {
+			return nil, fmt.Errorf("%w: changed ID %q not found", ErrDeltaChainBroken, code.ID)
+		}
+		result[i] = code
+	}
+
+	removed := make(map[string]bool, len(delta.Removed))
+	for _, id := range delta.Removed {
+		if _, ok := index[id]; !ok {
+			return nil, fmt.Errorf("%w: removed ID %q not found", ErrDeltaChainBroken, id)
+		}
+		removed[id] = true
+	}
+	kept := result[:0]
+	for _, code := range result {
+		if !removed[code.ID] {
+			kept = append(kept, code)
+		}
+	}
+	result = kept
+
+	for _, code := range delta.Added {
+		if _, ok := index[code.ID]; ok && !removed[code.ID] {
+			return nil, fmt.Errorf("%w: added ID %q already exists", ErrDeltaChainBroken, code.ID)
+		}
+		result = append(result, code)
+	}
+
+	if hash := hashCodeSet(result); hash != delta.ResultHash {
+		return nil, fmt.Errorf("%w: result hash %s, expected %s", ErrDeltaChainBroken, hash, delta.ResultHash)
+	}
+	return result, nil
+}
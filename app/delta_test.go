@@ -0,0 +1,106 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// sameCodes reports whether a and b hold the same entries in any order
+func sameCodes(a, b []app.WeaponCode) bool {
+	sorted := func(codes []app.WeaponCode) []app.WeaponCode {
+		codes = append([]app.WeaponCode(nil), codes...)
+		sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+		return codes
+	}
+	return reflect.DeepEqual(sorted(a), sorted(b))
+}
+
+// TestDeltaSync checks that changes are applied as deltas, and that a
+// broken delta chain falls back to a full fetch
+func TestDeltaSync(t *testing.T) {
+	v1 := apitest.Codes(1)
+	server := apitest.NewServer(t, v1)
+	loader, cm := newLoader(t, server.URL, app.DataSourceConfig{})
+
+	// refresh runs one refresh and checks the cache now holds want
+	refresh := func(step string, want []app.WeaponCode, wantDeltas int) {
+		t.Helper()
+		if _, _, err := loader.Refresh(context.Background()); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		cache, err := cm.LoadEnvelope()
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if !sameCodes(cache.WeaponCodes, want) {
+			t.Fatalf("%s: cache holds %d codes that differ from the %d served", step, len(cache.WeaponCodes), len(want))
+		}
+		if deltas := server.Stats().Deltas; deltas != wantDeltas {
+			t.Fatalf("%s: server sent %d deltas, want %d", step, deltas, wantDeltas)
+		}
+	}
+
+	refresh("first fetch", v1, 0)
+
+	// Change one entry, remove one and add one
+	v2 := append([]app.WeaponCode{}, v1...)
+	v2[0].Code = apitest.Code(98)
+	v2 = v2[:len(v2)-1]
+	v2 = append(v2, app.WeaponCode{ID: "mp5", Mode: "烽火地带", Name: "MP5", Tier: "T2", Code: apitest.Code(99), Source: "刀仔"})
+	server.SetCodes(v2)
+	refresh("delta", v2, 1)
+
+	// Tamper with the local copy so the next delta no longer applies
+	cache, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := cache.Endpoints[app.WeaponCodesPath]
+	if err := cm.Save(cache.WeaponCodes[1:], "api"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.MarkChecked(app.WeaponCodesPath, endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	v3 := append([]app.WeaponCode{}, v2...)
+	v3[0].Tier = "T1"
+	server.SetCodes(v3)
+	refresh("broken chain", v3, 2)
+
+	// A server that no longer knows our version sends everything
+	v4 := append([]app.WeaponCode{}, v3...)
+	v4[0].Tier = "T0"
+	server.SetCodes(v4)
+	server.ForgetHistory()
+	refresh("unknown version", v4, 2)
+}
+
+func TestApplyDelta(t *testing.T) {
+	v1, v2 := apitest.Codes(1), apitest.Codes(2)[1:]
+	v2 = append(v2, app.WeaponCode{ID: "mp5", Mode: "烽火地带", Name: "MP5", Code: apitest.Code(99), Source: "刀仔"})
+
+	delta := app.DiffCodes(v1, v2, "v1")
+	if len(delta.Added) != 1 || len(delta.Changed) != 2 || len(delta.Removed) != 1 {
+		t.Fatalf("delta: %d added, %d changed, %d removed", len(delta.Added), len(delta.Changed), len(delta.Removed))
+	}
+	got, err := app.ApplyDelta(v1, "v1", delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameCodes(got, v2) {
+		t.Errorf("applied delta gives %+v, want %+v", got, v2)
+	}
+
+	if _, err := app.ApplyDelta(v1, "v0", delta); err == nil {
+		t.Error("delta applied to the wrong base version")
+	}
+	if _, err := app.ApplyDelta(v1[1:], "v1", delta); err == nil {
+		t.Error("delta applied to codes that do not hash to its result")
+	}
+}
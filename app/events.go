@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EventsPath streams VersionEvents as Server-Sent Events
+	EventsPath = "/api/events"
+	// VersionEventName is the SSE event type of a VersionEvent
+	VersionEventName = "version"
+	// eventsKeepAlive is how often an idle stream gets a comment line, so
+	// proxies do not close it
+	eventsKeepAlive = 25 * time.Second
+	// eventsRetry is the reconnect delay suggested to clients
+	eventsRetry = 3 * time.Second
+)
+
+// ErrEventsUnsupported is returned by Subscribe when the server has no
+// event stream; the caller should stick to polling
+var ErrEventsUnsupported = errors.New("server does not stream events")
+
+// VersionEvent announces that the served codes changed
+// Its SSE id is Version, so a client reconnecting with Last-Event-ID only
+// hears about versions it missed.
+type VersionEvent struct {
+	Version     string `json:"version"`
+	LastUpdated string `json:"last_updated"`
+	TotalCount  int    `json:"total_count"`
+	// PreviousVersion is the version the summary is against; it is empty
+	// when the client's version is unknown to the server
+	PreviousVersion string `json:"previous_version,omitempty"`
+	Added           int    `json:"added"`
+	Changed         int    `json:"changed"`
+	Removed         int    `json:"removed"`
+}
+
+// versionEvent describes the change from the version with ID since
+// (empty or unknown = no summary) to current
+func versionEvent(current *servedVersion, history []*servedVersion, since string) VersionEvent {
+	event := VersionEvent{
+		Version:     current.version,
+		LastUpdated: formatCacheTime(current.modified),
+		TotalCount:  len(current.codes),
+	}
+	for _, old := range history {
+		if old.version == since {
+			delta := DiffCodes(old.codes, current.codes, since)
+			event.PreviousVersion = since
+			event.Added, event.Changed, event.Removed = len(delta.Added), len(delta.Changed), len(delta.Removed)
+			break
+		}
+	}
+	return event
+}
+
+// subscribe registers a stream for version events
+// The channel holds only the latest event: a slow stream skips versions
+// but always ends up on the current one.
+func (s *CodeServer) subscribe() (chan VersionEvent, func()) {
+	ch := make(chan VersionEvent, 1)
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan VersionEvent]bool)
+	}
+	s.subscribers[ch] = true
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// broadcastLocked sends event to every stream; callers hold s.mu
+func (s *CodeServer) broadcastLocked(event VersionEvent) {
+	for ch := range s.subscribers {
+		select {
+		case <-ch: // drop the event the stream has not picked up yet
+		default:
+		}
+		ch <- event
+	}
+}
+
+// handleEvents streams a VersionEvent whenever the served codes change
+// A client whose Last-Event-ID is not the current version gets an event
+// for the current version right away.
+func (s *CodeServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	events, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+
+	if current, history := s.snapshot(); current != nil {
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != current.version {
+			if err := writeVersionEvent(w, versionEvent(current, history, lastID)); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := writeVersionEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeVersionEvent writes event in the SSE format
+func writeVersionEvent(w http.ResponseWriter, event VersionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Version, VersionEventName, data)
+	return err
+}
+
+// Subscribe listens to the server's event stream and calls handle for
+// every VersionEvent until ctx is cancelled. Dropped connections are
+// reopened with Last-Event-ID, starting from lastEventID (the data version
+// the caller already has, or empty). It returns ErrEventsUnsupported if
+// the server has no event stream.
+func (api *APIClient) Subscribe(ctx context.Context, lastEventID string, handle func(VersionEvent)) error {
+	// The stream stays open, so the per-request timeout must not apply
+	client := &http.Client{Transport: api.httpClient.Transport}
+	retry := eventsRetry
+
+	for attempt := 0; ; attempt++ {
+		connected, err := api.streamEvents(ctx, client, &lastEventID, &retry, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrEventsUnsupported) {
+			return err
+		}
+		if connected {
+			attempt = 0
+		}
+
+		delay := retry
+		if attempt > 0 {
+			delay = max(delay, api.retry.backoff(attempt-1))
+		}
+		fmt.Printf("Event stream from %s ended (%v), reconnecting in %s\n", api.baseURL, err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// streamEvents reads one connection of the event stream until it ends
+// connected reports whether the server accepted the stream.
+func (api *APIClient) streamEvents(ctx context.Context, client *http.Client, lastEventID *string, retry *time.Duration, handle func(VersionEvent)) (connected bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api.baseURL+EventsPath, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if api.apiKey != "" {
+		req.Header.Set(APIKeyHeader, api.apiKey)
+	}
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, ErrEventsUnsupported
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			*retry = wait
+		}
+		return false, fmt.Errorf("API returned status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var id, event string
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event
+			if event == VersionEventName && len(data) > 0 {
+				var versionEvent VersionEvent
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &versionEvent); err == nil {
+					*lastEventID = id
+					handle(versionEvent)
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("stream closed by server")
+}
+
+// WatchUpdates follows the event stream of the first mirror in
+// orderedMirrors and calls onUpdate whenever it announces a version the
+// cache does not hold yet. Mirrors without a stream are skipped. It returns
+// when ctx is cancelled, or ErrEventsUnsupported when no mirror streams events.
+func (loader *WeaponCodeLoader) WatchUpdates(ctx context.Context, onUpdate func(VersionEvent)) error {
+	// The server announces codeSetVersion hashes, so the cached codes are
+	// hashed the same way whether they came as JSON or as a data pack
+	cachedVersion := func() string {
+		if !loader.config.UseLocalCache {
+			return ""
+		}
+		cache, err := loader.cacheManager.readEnvelope()
+		if err != nil {
+			return ""
+		}
+		return codeSetVersion(cache.WeaponCodes)
+	}
+
+	for _, m := range loader.orderedMirrors() {
+		err := m.client.Subscribe(ctx, cachedVersion(), func(event VersionEvent) {
+			if event.Version != cachedVersion() {
+				onUpdate(event)
+			}
+		})
+		if !errors.Is(err, ErrEventsUnsupported) {
+			return err
+		}
+	}
+	return ErrEventsUnsupported
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next event, failing the test after timeout
+func nextEvent(t *testing.T, events <-chan VersionEvent, step string, timeout time.Duration) VersionEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(timeout):
+		t.Fatalf("%s: no event within %s", step, timeout)
+		return VersionEvent{}
+	}
+}
+
+// TestEvents follows the event stream while new versions are published and
+// checks the delta summaries, that a watching loader only hears about
+// versions it lacks, and that a dropped stream resumes
+func TestEvents(t *testing.T) {
+	server, served := newTestCodeServer(t, testCodes(1))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// publish serves a new version of the codes and returns it
+	publish := func(version int) string {
+		t.Helper()
+		if err := served.Save(testCodes(version), "local"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		current, _ := server.snapshot()
+		return current.version
+	}
+
+	loader, _ := newTestLoader(t, ts.URL)
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The loader is up to date, so connecting announces nothing
+	updates := make(chan VersionEvent, 8)
+	go loader.WatchUpdates(ctx, func(event VersionEvent) { updates <- event })
+	select {
+	case event := <-updates:
+		t.Fatalf("connect: up-to-date loader was told about %s", event.Version)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	v1 := loader.cacheManager.EndpointValidators(WeaponCodesPath).DataVersion
+	v2 := publish(2)
+	event := nextEvent(t, updates, "publish", 2*time.Second)
+	if event.Version != v2 || event.PreviousVersion != v1 || event.Changed != len(testCodes(2)) {
+		t.Fatalf("publish: got %+v, want %s -> %s with %d changed", event, v1, v2, len(testCodes(2)))
+	}
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that was away reconnects with its last ID and catches up
+	client := NewAPIClient(ts.URL)
+	missed := make(chan VersionEvent, 8)
+	go client.Subscribe(ctx, v1, func(event VersionEvent) { missed <- event })
+	if event := nextEvent(t, missed, "catch up", 2*time.Second); event.Version != v2 || event.PreviousVersion != v1 {
+		t.Fatalf("catch up: got %s -> %s, want %s -> %s", event.PreviousVersion, event.Version, v1, v2)
+	}
+
+	// Dropped connections are reopened and the next publish still arrives
+	ts.CloseClientConnections()
+	time.Sleep(100 * time.Millisecond)
+	v3 := publish(3)
+	if event := nextEvent(t, missed, "reconnect", eventsRetry+2*time.Second); event.Version != v3 || event.PreviousVersion != v2 {
+		t.Fatalf("reconnect: got %s -> %s, want %s -> %s", event.PreviousVersion, event.Version, v2, v3)
+	}
+}
+
+// TestWatchUpdatesWithoutDataVersion checks that a cache filled without an
+// API version, such as from a data pack, is still recognised as current
+func TestWatchUpdatesWithoutDataVersion(t *testing.T) {
+	server, _ := newTestCodeServer(t, testCodes(1))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	loader, _ := newTestLoader(t, ts.URL)
+	if err := loader.cacheManager.Save(testCodes(1), "pack"); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan VersionEvent, 8)
+	go loader.WatchUpdates(ctx, func(event VersionEvent) { updates <- event })
+	select {
+	case event := <-updates:
+		t.Fatalf("cache holding the served codes was told about %s", event.Version)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
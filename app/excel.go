@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xuri/excelize/v2"
 )
@@ -29,20 +30,15 @@ type WeaponCode struct {
 // Supports two file formats:
 // 1. 刀仔三角洲枪械改装.xlsx - Single sheet with data in multiple columns
 // 2. 武器大师地板的改枪码合集.xlsx - Multiple sheets with single column format
-// This method tries both sources and returns combined results
+// This method tries every known source and returns combined results
 func (a *App) LoadWeaponCodes() ([]WeaponCode, error) {
 	var allCodes []WeaponCode
 
-	// Try to load from 刀仔 file
-	daoZaiCodes, err := a.LoadWeaponCodesFromDaoZai()
-	if err == nil && len(daoZaiCodes) > 0 {
-		allCodes = append(allCodes, daoZaiCodes...)
-	}
-
-	// Try to load from 武器大师 file
-	weaponMasterCodes, err := a.LoadWeaponCodesFromWeaponMaster()
-	if err == nil && len(weaponMasterCodes) > 0 {
-		allCodes = append(allCodes, weaponMasterCodes...)
+	for _, def := range knownSources {
+		codes, err := loadExcelSource(def)
+		if err == nil && len(codes) > 0 {
+			allCodes = append(allCodes, codes...)
+		}
 	}
 
 	if len(allCodes) == 0 {
@@ -52,74 +48,95 @@ func (a *App) LoadWeaponCodes() ([]WeaponCode, error) {
 	return allCodes, nil
 }
 
+// Workbook file names of the Excel data sources
+const (
+	daoZaiFileName       = "刀仔三角洲枪械改装.xlsx"
+	weaponMasterFileName = "武器大师地板的改枪码合集.xlsx"
+)
+
 // LoadWeaponCodesFromDaoZai loads weapon codes from 刀仔 data source
 func (a *App) LoadWeaponCodesFromDaoZai() ([]WeaponCode, error) {
-	possiblePaths := []string{
-		"data/刀仔三角洲枪械改装.xlsx",
-		"",
-	}
-
-	exePath, err := os.Executable()
-	if err == nil {
-		exeDir := filepath.Dir(exePath)
-		possiblePaths[1] = filepath.Join(exeDir, "data", "刀仔三角洲枪械改装.xlsx")
-		possiblePaths = append(possiblePaths, filepath.Join(filepath.Dir(exeDir), "data", "刀仔三角洲枪械改装.xlsx"))
-	}
+	def, _ := findSourceDefinition("刀仔")
+	return loadExcelSource(def)
+}
 
-	var f *excelize.File
-	var lastErr error
-	for _, path := range possiblePaths {
-		if path == "" {
-			continue
-		}
-		f, err = excelize.OpenFile(path)
-		if err == nil {
-			break
-		}
-		lastErr = err
-	}
+// LoadWeaponCodesFromWeaponMaster loads weapon codes from 武器大师 data source
+func (a *App) LoadWeaponCodesFromWeaponMaster() ([]WeaponCode, error) {
+	def, _ := findSourceDefinition("武器大师")
+	return loadExcelSource(def)
+}
 
-	if f == nil {
-		return nil, fmt.Errorf("failed to open 刀仔 file, last error: %w", lastErr)
+// loadExcelSource opens and parses the workbook of a known source
+func loadExcelSource(def sourceDefinition) ([]WeaponCode, error) {
+	f, _, err := openSourceWorkbook(def.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s file, last error: %w", def.Name, err)
 	}
 	defer f.Close()
 
-	return parse刀仔File(f)
+	return def.parseWorkbook(f)
 }
 
-// LoadWeaponCodesFromWeaponMaster loads weapon codes from 武器大师 data source
-func (a *App) LoadWeaponCodesFromWeaponMaster() ([]WeaponCode, error) {
+// openSourceWorkbook opens a source workbook from the first data directory
+// that has it. It returns the opened file and its path.
+func openSourceWorkbook(fileName string) (*excelize.File, string, error) {
 	possiblePaths := []string{
-		"data/武器大师地板的改枪码合集.xlsx",
-		"",
+		filepath.Join("data", fileName),
 	}
 
 	exePath, err := os.Executable()
 	if err == nil {
 		exeDir := filepath.Dir(exePath)
-		possiblePaths[1] = filepath.Join(exeDir, "data", "武器大师地板的改枪码合集.xlsx")
-		possiblePaths = append(possiblePaths, filepath.Join(filepath.Dir(exeDir), "data", "武器大师地板的改枪码合集.xlsx"))
+		possiblePaths = append(possiblePaths, filepath.Join(exeDir, "data", fileName))
+		possiblePaths = append(possiblePaths, filepath.Join(filepath.Dir(exeDir), "data", fileName))
 	}
 
-	var f *excelize.File
 	var lastErr error
 	for _, path := range possiblePaths {
-		if path == "" {
-			continue
-		}
-		f, err = excelize.OpenFile(path)
+		f, err := excelize.OpenFile(path)
 		if err == nil {
-			break
+			return f, path, nil
 		}
 		lastErr = err
 	}
 
-	if f == nil {
-		return nil, fmt.Errorf("failed to open 武器大师 file, last error: %w", lastErr)
+	return nil, "", lastErr
+}
+
+// workbookDataAsOf returns when a workbook's content last changed
+// It prefers the modification time in the document properties, which
+// survives copying, and falls back to the file modification time.
+func workbookDataAsOf(f *excelize.File, path string) time.Time {
+	if props, err := f.GetDocProps(); err == nil && props.Modified != "" {
+		if t, err := time.Parse(time.RFC3339, props.Modified); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// excelSourceMeta returns the cache metadata of a source parsed from the
+// workbook f, read from path
+func excelSourceMeta(def sourceDefinition, f *excelize.File, path string, parsedAt time.Time) SourceMeta {
+	meta := SourceMeta{
+		Name:          def.Name,
+		DisplayName:   def.DisplayName,
+		Homepage:      def.Homepage,
+		FetchedAt:     formatCacheTime(parsedAt),
+		ParsedAt:      formatCacheTime(parsedAt),
+		ParserVersion: excelParserVersion,
 	}
-	defer f.Close()
 
-	return parseWeaponMasterFile(f)
+	if dataAsOf := workbookDataAsOf(f, path); !dataAsOf.IsZero() {
+		meta.DataAsOf = formatCacheTime(dataAsOf)
+	}
+	if fileHash, err := hashFile(path); err == nil {
+		meta.FileHash = fileHash
+	}
+	return meta
 }
 
 // parse刀仔File parses the 刀仔 format file (single sheet, multiple columns)
@@ -0,0 +1,366 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// FeedPath serves an Atom feed of added and changed codes; it takes the
+	// filter parameters of WeaponCodesPath
+	FeedPath = "/feed.atom"
+	// FeedFileName is where the feed command writes by default
+	FeedFileName = "weapon_codes.atom"
+	// maxFeedEntries caps how many entries a feed carries, newest first
+	maxFeedEntries = 100
+	// FeedHistoryDirName is the directory under the data directory where
+	// the serve command keeps the snapshots its feed is built from
+	FeedHistoryDirName = "feed"
+	// maxFeedSnapshots is how many snapshots the served feed is built from
+	maxFeedSnapshots = 32
+	// feedSnapshotTimeFormat starts snapshot file names, so they sort by time
+	feedSnapshotTimeFormat = "20060102T150405Z"
+	// feedTagPrefix starts the tag: URIs identifying feeds and entries
+	feedTagPrefix = "tag:delta-tool,2025:"
+)
+
+// FeedSnapshot is one version of the codes a feed is built from
+type FeedSnapshot struct {
+	Version string
+	Updated time.Time
+	Codes   []WeaponCode
+}
+
+// FeedOptions selects the entries of a feed and how it links to itself
+type FeedOptions struct {
+	// Filter is applied to every entry; paging and UpdatedSince are ignored
+	Filter FetchOptions
+	// SelfURL is where the feed is served, "" for an exported file
+	SelfURL string
+}
+
+// atomFeed and the types below are the parts of RFC 4287 the feed uses
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// BuildFeed returns an Atom feed of the entries added or changed from one
+// snapshot to the next (snapshots oldest first), newest first. The first
+// snapshot only serves as the base of the second.
+func BuildFeed(snapshots []FeedSnapshot, opts FeedOptions) ([]byte, error) {
+	filter := opts.Filter
+	filter.Cursor, filter.Limit, filter.UpdatedSince = "", 0, time.Time{}
+
+	feed := atomFeed{
+		Title:  feedTitle(filter),
+		ID:     feedTagPrefix + "feed",
+		Author: atomPerson{Name: "delta-tool"},
+	}
+	if query := filter.Values().Encode(); query != "" {
+		feed.ID += "?" + query
+	}
+	if opts.SelfURL != "" {
+		feed.Links = append(feed.Links, atomLink{Rel: "self", Href: opts.SelfURL})
+	}
+	if len(snapshots) > 0 {
+		feed.Updated = snapshots[len(snapshots)-1].Updated.UTC().Format(time.RFC3339)
+	} else {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	// Caches generated before stable IDs numbered their rows, so entries
+	// are matched by stable ID whatever the snapshots carry
+	keyed := make([][]WeaponCode, len(snapshots))
+	for i, snapshot := range snapshots {
+		keyed[i] = append([]WeaponCode(nil), snapshot.Codes...)
+		assignStableIDs(keyed[i])
+	}
+
+	for i := len(snapshots) - 1; i > 0 && len(feed.Entries) < maxFeedEntries; i-- {
+		snapshot := snapshots[i]
+		delta := DiffCodes(keyed[i-1], keyed[i], snapshots[i-1].Version)
+		added, _, _, err := FilterWeaponCodes(delta.Added, filter, snapshot.Updated)
+		if err != nil {
+			return nil, err
+		}
+		changed, _, _, err := FilterWeaponCodes(delta.Changed, filter, snapshot.Updated)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range added {
+			feed.Entries = append(feed.Entries, feedEntry(snapshot, code, "新增"))
+		}
+		for _, code := range changed {
+			feed.Entries = append(feed.Entries, feedEntry(snapshot, code, "更新"))
+		}
+	}
+	if len(feed.Entries) > maxFeedEntries {
+		feed.Entries = feed.Entries[:maxFeedEntries]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return nil, fmt.Errorf("failed to encode feed: %w", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// feedTitle names a feed after its filters
+func feedTitle(filter FetchOptions) string {
+	title := "三角洲改枪码更新"
+	for _, part := range []string{filter.Weapon, filter.Class, filter.Tier, filter.Mode, filter.Source} {
+		if part != "" {
+			title += " · " + part
+		}
+	}
+	return title
+}
+
+// feedEntry describes one added or changed code
+func feedEntry(snapshot FeedSnapshot, code WeaponCode, change string) atomEntry {
+	title := change + " " + code.Name
+	if code.Build != "" {
+		title += " " + code.Build
+	}
+	title += "（" + code.Source + "）"
+
+	price := "暂无"
+	if code.Price != nil {
+		price = fmt.Sprintf("%d万", *code.Price)
+	}
+	var content strings.Builder
+	fmt.Fprintf(&content, "武器：%s\n", code.Name)
+	fmt.Fprintf(&content, "改装：%s\n", code.Build)
+	fmt.Fprintf(&content, "模式：%s\n", code.Mode)
+	fmt.Fprintf(&content, "来源：%s\n", code.Source)
+	fmt.Fprintf(&content, "价格：%s\n", price)
+	fmt.Fprintf(&content, "改枪码：%s", code.Code)
+
+	return atomEntry{
+		Title:   title,
+		ID:      feedTagPrefix + snapshot.Version + "/" + code.ID,
+		Updated: snapshot.Updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: code.Source},
+		Categories: []atomCategory{
+			{Term: code.Mode},
+			{Term: weaponClass(code.Name)},
+		},
+		Content: atomText{Type: "text", Body: content.String()},
+	}
+}
+
+// handleFeed serves the Atom feed of the kept snapshots, or of the versions
+// the server remembers when EnableFeedHistory was not called
+func (s *CodeServer) handleFeed(w http.ResponseWriter, r *http.Request) {
+	current, history := s.snapshot()
+	if current == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, fmt.Errorf("no weapon codes loaded"))
+		return
+	}
+	filter, err := ParseFetchOptions(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	snapshots := s.feedSnapshots()
+	if snapshots == nil {
+		snapshots = make([]FeedSnapshot, 0, len(history)+1)
+		for _, v := range append(history[:len(history):len(history)], current) {
+			snapshots = append(snapshots, v.feedSnapshot())
+		}
+	}
+	// The request's Host is not trusted, so without a configured public
+	// URL the feed has no self link
+	opts := FeedOptions{Filter: filter}
+	if s.publicURL != "" {
+		opts.SelfURL = s.publicURL + r.URL.RequestURI()
+	}
+	body, err := BuildFeed(snapshots, opts)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("ETag", `"`+strings.TrimPrefix(hashBytes(body), hashPrefix)[:16]+`"`)
+	http.ServeContent(w, r, "", current.modified, bytes.NewReader(body))
+}
+
+// LoadFeedSnapshot reads a cache file as a feed snapshot
+// The version is the one the serve command would publish it under, so
+// entries keep their IDs between the exported and the served feed.
+func LoadFeedSnapshot(path string) (FeedSnapshot, error) {
+	cache, err := NewCacheManager(path).readEnvelope()
+	if err != nil {
+		return FeedSnapshot{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	updated, err := parseCacheTime(cache.LastUpdated)
+	if err != nil {
+		return FeedSnapshot{}, fmt.Errorf("%s: invalid last_updated: %w", path, err)
+	}
+	return FeedSnapshot{
+		Version: codeSetVersion(cache.WeaponCodes),
+		Updated: updated.UTC().Truncate(time.Second),
+		Codes:   cache.WeaponCodes,
+	}, nil
+}
+
+// EnableFeedHistory keeps the snapshots the feed is built from as cache
+// files in dir, so the feed survives a restart. The snapshots already in
+// dir are loaded; call it before the first Reload, which adds the served
+// cache as the newest snapshot.
+func (s *CodeServer) EnableFeedHistory(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create feed directory: %w", err)
+	}
+	files, err := feedSnapshotFiles(dir)
+	if err != nil {
+		return err
+	}
+	var snapshots []FeedSnapshot
+	for _, file := range files {
+		snapshot, err := LoadFeedSnapshot(file)
+		if err != nil {
+			fmt.Printf("Warning: Skipping feed snapshot: %v\n", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if len(snapshots) > maxFeedSnapshots {
+		snapshots = snapshots[len(snapshots)-maxFeedSnapshots:]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feedDir = dir
+	s.feed = append([]FeedSnapshot{}, snapshots...)
+	return nil
+}
+
+// SetPublicURL sets the base URL the server is reached at, such as
+// "https://codes.example.com", which the feed links to itself with
+func (s *CodeServer) SetPublicURL(base string) {
+	s.publicURL = strings.TrimSuffix(base, "/")
+}
+
+// feedSnapshots returns the kept snapshots, nil without EnableFeedHistory
+func (s *CodeServer) feedSnapshots() []FeedSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.feed
+}
+
+// recordFeedLocked adds a published version to the kept snapshots and
+// writes it to the feed directory; callers hold s.mu
+func (s *CodeServer) recordFeedLocked(v *servedVersion) {
+	if s.feedDir == "" {
+		return
+	}
+	if n := len(s.feed); n > 0 && s.feed[n-1].Version == v.version {
+		return
+	}
+	s.feed = append(s.feed, v.feedSnapshot())
+	if len(s.feed) > maxFeedSnapshots {
+		s.feed = s.feed[len(s.feed)-maxFeedSnapshots:]
+	}
+
+	name := v.modified.UTC().Format(feedSnapshotTimeFormat) + "-" + v.version + ".json.gz"
+	if err := writeFeedSnapshot(filepath.Join(s.feedDir, name), v); err != nil {
+		fmt.Printf("Warning: Failed to keep feed snapshot: %v\n", err)
+		return
+	}
+	if err := pruneFeedSnapshots(s.feedDir); err != nil {
+		fmt.Printf("Warning: Failed to prune feed snapshots: %v\n", err)
+	}
+}
+
+// feedSnapshot returns the feed snapshot of a served version
+func (v *servedVersion) feedSnapshot() FeedSnapshot {
+	return FeedSnapshot{Version: v.version, Updated: v.modified, Codes: v.codes}
+}
+
+// writeFeedSnapshot writes a served version as a cache file that
+// LoadFeedSnapshot reads back under the same version and time
+func writeFeedSnapshot(path string, v *servedVersion) error {
+	cm := NewCacheManager(path)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.writeEnvelopeLocked(&WeaponCodeCache{
+		Version:     CacheVersion,
+		LastUpdated: formatCacheTime(v.modified),
+		TotalCount:  len(v.codes),
+		DataSource:  "feed",
+		ContentHash: hashWeaponCodes(v.codes),
+		WeaponCodes: v.codes,
+	})
+}
+
+// feedSnapshotFiles lists the snapshot files in dir, oldest first
+func feedSnapshotFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feed snapshots: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// pruneFeedSnapshots removes all but the newest maxFeedSnapshots files
+func pruneFeedSnapshots(dir string) error {
+	files, err := feedSnapshotFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > maxFeedSnapshots {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFeed publishes a new M14 build and an updated code and checks the
+// served feeds, and that the exported feed of the same snapshots agrees
+func TestFeed(t *testing.T) {
+	dir := t.TempDir()
+	before := testCodes(1)
+	after := append(testCodes(1), WeaponCode{ID: "4", Mode: "烽火地带", Name: "M14", Tier: "T0", Build: "新版满配", Code: testCode(99), Source: "武器大师"})
+	after[1].Code = testCode(98)
+
+	var snapshotFiles []string
+	served := NewCacheManager(filepath.Join(dir, CompressedCacheFileName))
+	server := NewCodeServer(served)
+	for i, codes := range [][]WeaponCode{before, after} {
+		if err := served.Save(codes, "local"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		// Keep each version around for the export
+		data, err := os.ReadFile(served.GetCachePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("snapshot-%d.json.gz", i))
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		snapshotFiles = append(snapshotFiles, file)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	// fetch decodes a served feed
+	fetch := func(query url.Values) atomFeed {
+		t.Helper()
+		resp, err := http.Get(ts.URL + FeedPath + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("feed %s: status %d", query.Encode(), resp.StatusCode)
+		}
+		var feed atomFeed
+		if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+			t.Fatalf("feed %s: %v", query.Encode(), err)
+		}
+		return feed
+	}
+
+	all := fetch(nil)
+	if len(all.Entries) != 2 || !strings.HasPrefix(all.Entries[0].Title, "新增 M14 新版满配") || !strings.HasPrefix(all.Entries[1].Title, "更新 AK-47") {
+		t.Fatalf("feed: unexpected entries %+v", all.Entries)
+	}
+	if content := all.Entries[0].Content.Body; !strings.Contains(content, testCode(99)) || !strings.Contains(content, "来源：武器大师") {
+		t.Fatalf("feed: entry content %q lacks the code or source", content)
+	}
+	for _, check := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{WeaponParam: {"m14"}}, 1},
+		{url.Values{WeaponParam: {"M4A1"}}, 0},
+		{url.Values{SourceParam: {"武器大师"}}, 2},
+		{url.Values{SourceParam: {"刀仔"}}, 0},
+	} {
+		if feed := fetch(check.query); len(feed.Entries) != check.want {
+			t.Errorf("feed %s: %d entries, want %d", check.query.Encode(), len(feed.Entries), check.want)
+		}
+	}
+
+	var snapshots []FeedSnapshot
+	for _, file := range snapshotFiles {
+		snapshot, err := LoadFeedSnapshot(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	data, err := BuildFeed(snapshots, FeedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported atomFeed
+	if err := xml.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(exported.Entries) != len(all.Entries) || exported.Entries[0].ID != all.Entries[0].ID {
+		t.Fatal("export: entries differ from the served feed")
+	}
+}
+
+// TestFeedHistory restarts a server with a feed directory and checks the
+// feed still has the entries published before the restart
+func TestFeedHistory(t *testing.T) {
+	dir := t.TempDir()
+	feedDir := filepath.Join(dir, FeedHistoryDirName)
+	served := NewCacheManager(filepath.Join(dir, CompressedCacheFileName))
+	after := append(testCodes(1), WeaponCode{ID: "4", Mode: "烽火地带", Name: "M14", Tier: "T0", Build: "新版满配", Code: testCode(99), Source: "武器大师"})
+
+	// start runs a server on the feed directory
+	start := func() *CodeServer {
+		t.Helper()
+		server := NewCodeServer(served)
+		if err := server.EnableFeedHistory(feedDir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+	// entries fetches the feed of server
+	entries := func(server *CodeServer) []atomEntry {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, FeedPath, nil))
+		var feed atomFeed
+		if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed.Entries
+	}
+
+	if err := served.Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+	server := start()
+	if err := served.Save(after, "local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	before := entries(server)
+	if len(before) != 1 {
+		t.Fatalf("feed before the restart: %d entries, want 1", len(before))
+	}
+
+	restarted := start()
+	got := entries(restarted)
+	if len(got) != 1 || got[0].ID != before[0].ID {
+		t.Fatalf("feed after the restart: %+v, want %+v", got, before)
+	}
+	if files, _ := feedSnapshotFiles(feedDir); len(files) != 2 {
+		t.Errorf("%d snapshot files kept, want 2", len(files))
+	}
+}
+
+// TestFeedSelfLink checks the self link comes from the public URL and
+// never from the request's Host
+func TestFeedSelfLink(t *testing.T) {
+	server, _ := newTestCodeServer(t, testCodes(1))
+
+	// links fetches the feed with a forged Host and returns its links
+	links := func() []atomLink {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, FeedPath+"?weapon=M14", nil)
+		req.Host = "evil.example"
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		var feed atomFeed
+		if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed.Links
+	}
+
+	if got := links(); len(got) != 0 {
+		t.Errorf("self link without a public URL: %+v", got)
+	}
+	server.SetPublicURL("https://codes.example.com/")
+	want := "https://codes.example.com" + FeedPath + "?weapon=M14"
+	if got := links(); len(got) != 1 || got[0].Href != want {
+		t.Errorf("self link %+v, want %s", got, want)
+	}
+}
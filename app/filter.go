@@ -0,0 +1,261 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of the weapon codes endpoint
+const (
+	ModeParam         = "mode"
+	SourceParam       = "source"
+	WeaponParam       = "weapon"
+	ClassParam        = "class"
+	TierParam         = "tier"
+	MinPriceParam     = "min_price"
+	MaxPriceParam     = "max_price"
+	UpdatedSinceParam = "updated_since"
+	CursorParam       = "cursor"
+	LimitParam        = "limit"
+)
+
+// MaxPageLimit caps the page size a client can ask for
+const MaxPageLimit = 1000
+
+// FetchOptions selects which weapon codes the API returns
+// The zero value asks for everything in one response.
+type FetchOptions struct {
+	// Mode is the game mode (烽火地带 or 全面战场)
+	Mode string
+	// Source is the data source (刀仔, 武器大师, ...)
+	Source string
+	// Weapon matches weapon names containing it, ignoring case
+	Weapon string
+	// Class is the weapon class (突击步枪, 冲锋枪, ...)
+	Class string
+	Tier  string
+	// MinPrice and MaxPrice bound the build price (万); entries without a
+	// price are left out when either is set
+	MinPrice *int
+	MaxPrice *int
+	// UpdatedSince keeps entries updated on or after this date; entries
+	// without an update time are left out
+	UpdatedSince time.Time
+	// Cursor continues a previous page (APIResponse.NextCursor)
+	Cursor string
+	// Limit is the page size (0 = no paging)
+	Limit int
+}
+
+// IsZero reports whether the options select everything
+func (o FetchOptions) IsZero() bool {
+	return len(o.Values()) == 0
+}
+
+// Values encodes the options as query parameters
+func (o FetchOptions) Values() url.Values {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set(ModeParam, o.Mode)
+	set(SourceParam, o.Source)
+	set(WeaponParam, o.Weapon)
+	set(ClassParam, o.Class)
+	set(TierParam, o.Tier)
+	if o.MinPrice != nil {
+		values.Set(MinPriceParam, strconv.Itoa(*o.MinPrice))
+	}
+	if o.MaxPrice != nil {
+		values.Set(MaxPriceParam, strconv.Itoa(*o.MaxPrice))
+	}
+	if !o.UpdatedSince.IsZero() {
+		values.Set(UpdatedSinceParam, o.UpdatedSince.Format(time.RFC3339))
+	}
+	set(CursorParam, o.Cursor)
+	if o.Limit > 0 {
+		values.Set(LimitParam, strconv.Itoa(o.Limit))
+	}
+	return values
+}
+
+// ParseFetchOptions decodes the query parameters of a weapon codes request
+func ParseFetchOptions(values url.Values) (FetchOptions, error) {
+	opts := FetchOptions{
+		Mode:   values.Get(ModeParam),
+		Source: values.Get(SourceParam),
+		Weapon: values.Get(WeaponParam),
+		Class:  values.Get(ClassParam),
+		Tier:   values.Get(TierParam),
+		Cursor: values.Get(CursorParam),
+	}
+
+	parseInt := func(key string) (*int, error) {
+		raw := values.Get(key)
+		if raw == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, raw)
+		}
+		return &n, nil
+	}
+
+	var err error
+	if opts.MinPrice, err = parseInt(MinPriceParam); err != nil {
+		return opts, err
+	}
+	if opts.MaxPrice, err = parseInt(MaxPriceParam); err != nil {
+		return opts, err
+	}
+	if opts.MinPrice != nil && opts.MaxPrice != nil && *opts.MinPrice > *opts.MaxPrice {
+		return opts, fmt.Errorf("%s is greater than %s", MinPriceParam, MaxPriceParam)
+	}
+
+	limit, err := parseInt(LimitParam)
+	if err != nil {
+		return opts, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > MaxPageLimit {
+			return opts, fmt.Errorf("%s must be between 1 and %d", LimitParam, MaxPageLimit)
+		}
+		opts.Limit = *limit
+	}
+
+	if raw := values.Get(UpdatedSinceParam); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			if since, err = time.ParseInLocation(time.DateOnly, raw, time.Local); err != nil {
+				return opts, fmt.Errorf("invalid %s %q, want RFC 3339 or YYYY-MM-DD", UpdatedSinceParam, raw)
+			}
+		}
+		opts.UpdatedSince = since
+	}
+
+	return opts, nil
+}
+
+// FilterWeaponCodes applies the options to codes and returns one page of
+// matches, the total number of matches, and the cursor of the next page
+// ("" on the last page). now anchors update times that carry no year.
+func FilterWeaponCodes(codes []WeaponCode, opts FetchOptions, now time.Time) ([]WeaponCode, int, string, error) {
+	var matches []WeaponCode
+	weapon := strings.ToLower(opts.Weapon)
+	for _, code := range codes {
+		switch {
+		case opts.Mode != "" && code.Mode != opts.Mode,
+			opts.Source != "" && code.Source != opts.Source,
+			weapon != "" && !strings.Contains(strings.ToLower(code.Name), weapon),
+			opts.Class != "" && weaponClass(code.Name) != opts.Class,
+			opts.Tier != "" && code.Tier != opts.Tier:
+			continue
+		}
+		if opts.MinPrice != nil || opts.MaxPrice != nil {
+			if code.Price == nil ||
+				(opts.MinPrice != nil && *code.Price < *opts.MinPrice) ||
+				(opts.MaxPrice != nil && *code.Price > *opts.MaxPrice) {
+				continue
+			}
+		}
+		if !opts.UpdatedSince.IsZero() {
+			updated, ok := entryUpdatedAt(code, now)
+			if !ok || updated.Before(opts.UpdatedSince) {
+				continue
+			}
+		}
+		matches = append(matches, code)
+	}
+
+	// Resume at the position the cursor points at. Positions only hold
+	// for the code set the cursor was made from, so it carries its version.
+	start := 0
+	if opts.Cursor != "" {
+		version, offset, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if version != codeSetVersion(codes) {
+			return nil, 0, "", fmt.Errorf("invalid %s: the weapon codes changed, start again from the first page", CursorParam)
+		}
+		if offset > len(matches) {
+			return nil, 0, "", fmt.Errorf("invalid %s: past the last entry", CursorParam)
+		}
+		start = offset
+	}
+
+	page := matches[start:]
+	next := ""
+	if opts.Limit > 0 && len(page) > opts.Limit {
+		page = page[:opts.Limit]
+		next = encodeCursor(codeSetVersion(codes), start+opts.Limit)
+	}
+	if page == nil {
+		page = []WeaponCode{}
+	}
+	return page, len(matches), next, nil
+}
+
+// encodeCursor makes an opaque page cursor from the version of the code
+// set and the position of the next entry among the matches
+func encodeCursor(version string, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(version + ":" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the version and position a page cursor points at
+func decodeCursor(cursor string) (string, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid %s %q", CursorParam, cursor)
+	}
+	version, rawOffset, ok := strings.Cut(string(raw), ":")
+	offset, err := strconv.Atoi(rawOffset)
+	if !ok || err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("invalid %s %q", CursorParam, cursor)
+	}
+	return version, offset, nil
+}
+
+// monthDayPattern matches the "M.D" update times used by the workbooks
+// (some are typed with a full-width comma, such as "12，5")
+var monthDayPattern = regexp.MustCompile(`^(\d{1,2})\s*[.,，/-]\s*(\d{1,2})$`)
+
+// entryUpdatedAt parses an entry's update time
+// Full dates ("2025-01-04", "2025-01") are taken as is; month and day
+// without a year resolve to the latest such date not after now.
+func entryUpdatedAt(code WeaponCode, now time.Time) (time.Time, bool) {
+	if code.UpdateTime == nil {
+		return time.Time{}, false
+	}
+	raw := strings.TrimSpace(*code.UpdateTime)
+
+	for _, layout := range []string{time.DateOnly, "2006-01"} {
+		if t, err := time.ParseInLocation(layout, raw, now.Location()); err == nil {
+			return t, true
+		}
+	}
+
+	m := monthDayPattern.FindStringSubmatch(raw)
+	if m == nil {
+		return time.Time{}, false
+	}
+	month, _ := strconv.Atoi(m[1])
+	day, _ := strconv.Atoi(m[2])
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	t := time.Date(now.Year(), time.Month(month), day, 0, 0, 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
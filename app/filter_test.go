@@ -0,0 +1,150 @@
+package app
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFilterWeaponCodes(t *testing.T) {
+	price := func(n int) *int { return &n }
+	day := func(s string) *string { return &s }
+	codes := []WeaponCode{
+		{ID: "a", Name: "M14射手步枪", Mode: "烽火地带", Tier: "T0", Price: price(85), Source: "刀仔", UpdateTime: day("2025-01-04")},
+		{ID: "b", Name: "M4A1突击步枪", Mode: "全面战场", Tier: "T1", Price: price(40), Source: "刀仔"},
+		{ID: "c", Name: "MP5冲锋枪", Mode: "烽火地带", Tier: "T1", Source: "武器大师", UpdateTime: day("12.5")},
+	}
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{"mode=烽火地带", []string{"a", "c"}},
+		{"source=武器大师", []string{"c"}},
+		{"weapon=m4", []string{"b"}},
+		{"tier=T1&mode=全面战场", []string{"b"}},
+		{"min_price=50", []string{"a"}},
+		{"max_price=50", []string{"b"}},
+		{"updated_since=2025-01-01", []string{"a"}},
+		{"updated_since=2024-12-01", []string{"a", "c"}},
+		{"limit=2", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts, err := ParseFetchOptions(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _, _, err := FilterWeaponCodes(codes, opts, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, code := range page {
+				got = append(got, code.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFetchOptionsErrors(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=1001", "min_price=x", "min_price=5&max_price=1", "updated_since=yesterday"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseFetchOptions(values); err == nil {
+			t.Errorf("%s accepted", query)
+		}
+	}
+}
+
+func TestCursorWalksEveryPage(t *testing.T) {
+	codes := testCodes(1)
+	opts := FetchOptions{Limit: 1}
+	var walked []WeaponCode
+	for pages := 0; ; pages++ {
+		if pages > len(codes) {
+			t.Fatal("cursor never ran out")
+		}
+		page, total, next, err := FilterWeaponCodes(codes, opts, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != len(codes) {
+			t.Fatalf("total = %d, want %d", total, len(codes))
+		}
+		walked = append(walked, page...)
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if len(walked) != len(codes) {
+		t.Errorf("walked %d entries, want %d", len(walked), len(codes))
+	}
+
+	for _, cursor := range []string{"!", encodeCursor("gone", 1), encodeCursor(codeSetVersion(codes), len(codes)+1)} {
+		if _, _, _, err := FilterWeaponCodes(codes, FetchOptions{Cursor: cursor}, time.Now()); err == nil {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+}
+
+func TestCursorWithDuplicateIDs(t *testing.T) {
+	// Caches from before stable IDs repeat the same row IDs in every source
+	var codes []WeaponCode
+	for _, source := range []string{"刀仔", "武器大师"} {
+		for i := 0; i < 25; i++ {
+			codes = append(codes, WeaponCode{ID: strconv.Itoa(i), Name: "M4A1", Code: testCode(i), Source: source})
+		}
+	}
+
+	opts := FetchOptions{Limit: 7}
+	seen := make(map[string]int)
+	for pages := 0; ; pages++ {
+		if pages > len(codes) {
+			t.Fatal("cursor never ran out")
+		}
+		page, _, next, err := FilterWeaponCodes(codes, opts, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range page {
+			seen[code.Source+"/"+code.ID]++
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if len(seen) != len(codes) {
+		t.Errorf("walked %d distinct entries, want %d", len(seen), len(codes))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("%s returned %d times", key, n)
+		}
+	}
+
+	// A cursor does not carry over to a changed code set
+	_, _, next, err := FilterWeaponCodes(codes, FetchOptions{Limit: 7}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := FilterWeaponCodes(codes[1:], FetchOptions{Limit: 7, Cursor: next}, time.Now()); err == nil {
+		t.Error("cursor accepted for a changed code set")
+	}
+}
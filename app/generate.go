@@ -0,0 +1,179 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Per-source outcomes of a cache regeneration
+const (
+	// RefreshUpdated means the source was parsed and its entries replaced
+	RefreshUpdated = "updated"
+	// RefreshUnchanged means the workbook hash matched, so parsing was skipped
+	RefreshUnchanged = "unchanged"
+	// RefreshMissing means the workbook was not found; existing entries are kept
+	RefreshMissing = "missing"
+	// RefreshSkipped means the source was not selected; existing entries are kept
+	RefreshSkipped = "skipped"
+)
+
+// GenerateOptions selects what generate-cache rebuilds
+type GenerateOptions struct {
+	// Sources lists the sources to refresh (empty = every known source)
+	Sources []string
+	// Force reparses workbooks even when their hash is unchanged
+	Force bool
+}
+
+// SourceRefresh reports what happened to one source during regeneration
+type SourceRefresh struct {
+	Name   string
+	Status string
+	Count  int
+	Err    error
+}
+
+// GenerateResult is the outcome of a cache regeneration
+type GenerateResult struct {
+	Sources    []SourceRefresh
+	TotalCount int
+	// Changed is false when every source was kept as is and nothing was saved
+	Changed bool
+}
+
+// sourceUpdate is a replacement for one source's entries and metadata
+type sourceUpdate struct {
+	Meta  SourceMeta
+	Codes []WeaponCode
+}
+
+// regenerateFromExcel refreshes the selected sources of a cache from their
+// workbooks. Sources that are not selected, or whose workbook is missing,
+// keep their existing entries; workbooks whose stored hash is unchanged are
+// not parsed again unless opts.Force is set.
+func regenerateFromExcel(cm *CacheManager, opts GenerateOptions) (*GenerateResult, error) {
+	selected := make(map[string]bool)
+	for _, name := range opts.Sources {
+		if _, ok := findSourceDefinition(name); !ok {
+			return nil, fmt.Errorf("unknown source %q", name)
+		}
+		selected[name] = true
+	}
+
+	existing, err := cm.readEnvelope()
+	if err != nil {
+		// Start from scratch when there is no usable cache yet
+		existing = &WeaponCodeCache{}
+	}
+	existingMeta := make(map[string]SourceMeta)
+	for _, meta := range existing.SourceTable() {
+		existingMeta[meta.Name] = meta
+	}
+
+	result := &GenerateResult{}
+	updates := make(map[string]sourceUpdate)
+	parsedAt := time.Now()
+
+	for _, def := range knownSources {
+		refresh := SourceRefresh{Name: def.Name, Count: existingMeta[def.Name].RowCount}
+		if len(selected) > 0 && !selected[def.Name] {
+			refresh.Status = RefreshSkipped
+			result.Sources = append(result.Sources, refresh)
+			continue
+		}
+
+		f, path, err := openSourceWorkbook(def.FileName)
+		if err != nil {
+			refresh.Status = RefreshMissing
+			refresh.Err = err
+			result.Sources = append(result.Sources, refresh)
+			continue
+		}
+
+		fileHash, err := hashFile(path)
+		old, hadSource := existingMeta[def.Name]
+		if err == nil && !opts.Force && hadSource &&
+			old.FileHash == fileHash && old.ParserVersion == excelParserVersion {
+			f.Close()
+			refresh.Status = RefreshUnchanged
+			result.Sources = append(result.Sources, refresh)
+			continue
+		}
+
+		codes, err := def.parseWorkbook(f)
+		meta := excelSourceMeta(def, f, path, parsedAt)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", def.Name, err)
+		}
+
+		updates[def.Name] = sourceUpdate{Meta: meta, Codes: codes}
+		refresh.Status = RefreshUpdated
+		refresh.Count = len(codes)
+		result.Sources = append(result.Sources, refresh)
+	}
+
+	if len(updates) == 0 {
+		result.TotalCount = len(existing.WeaponCodes)
+		return result, nil
+	}
+
+	codes, sources := mergeSourceUpdates(existing, updates)
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no weapon codes found from any source")
+	}
+
+	if err := cm.Save(codes, "local-excel", sources...); err != nil {
+		return nil, err
+	}
+
+	result.TotalCount = len(codes)
+	result.Changed = true
+	return result, nil
+}
+
+// mergeSourceUpdates replaces the entries and metadata of the updated
+// sources in an existing cache and keeps every other source as is.
+// Known sources come first in their registry order, others follow by name.
+func mergeSourceUpdates(existing *WeaponCodeCache, updates map[string]sourceUpdate) ([]WeaponCode, []SourceMeta) {
+	codesBySource := make(map[string][]WeaponCode)
+	for _, code := range existing.WeaponCodes {
+		codesBySource[code.Source] = append(codesBySource[code.Source], code)
+	}
+	metaBySource := make(map[string]SourceMeta)
+	for _, meta := range existing.Sources {
+		metaBySource[meta.Name] = meta
+	}
+
+	for name, update := range updates {
+		codesBySource[name] = update.Codes
+		metaBySource[name] = update.Meta
+	}
+
+	var order []string
+	for _, def := range knownSources {
+		order = append(order, def.Name)
+	}
+	var others []string
+	for name := range codesBySource {
+		if _, ok := findSourceDefinition(name); !ok {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	order = append(order, others...)
+
+	var codes []WeaponCode
+	var sources []SourceMeta
+	for _, name := range order {
+		if len(codesBySource[name]) == 0 {
+			continue
+		}
+		codes = append(codes, codesBySource[name]...)
+		if meta, ok := metaBySource[name]; ok {
+			sources = append(sources, meta)
+		}
+	}
+	return codes, sources
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testWorkbook builds a 刀仔 workbook holding the given rows
+// (name, tier, price, build, code, range, update time)
+func testWorkbook(t *testing.T, rows [][]string) []byte {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.SetSheetName("Sheet1", "工作表1"); err != nil {
+		t.Fatal(err)
+	}
+	// Data starts below the header in row 11
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, 12+i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.SetSheetRow("工作表1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestRegenerateSelectedSource refreshes one source from its workbook and
+// checks that the other keeps its entries, and that an unchanged workbook
+// is not parsed again
+func TestRegenerateSelectedSource(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	workbook := testWorkbook(t, [][]string{
+		{"M4A1", "T0", "30w", "满配", testCode(101), "50米", "10.5"},
+		{"MP7", "T1", "20w", "腰射", testCode(102), "20米", "10.6"},
+		{"Vector", "T1", "25w", "满配", testCode(103), "20米", "10.6"},
+	})
+	if err := os.WriteFile(filepath.Join("data", daoZaiFileName), workbook, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewCacheManager(filepath.Join("data", CompressedCacheFileName))
+	if err := cm.Save(testCodes(1), "local-excel"); err != nil {
+		t.Fatal(err)
+	}
+	master := filterBySource(testCodes(1), "武器大师")
+
+	// status returns the outcome of one source
+	status := func(result *GenerateResult, name string) string {
+		for _, refresh := range result.Sources {
+			if refresh.Name == name {
+				return refresh.Status
+			}
+		}
+		return ""
+	}
+
+	result, err := regenerateFromExcel(cm, GenerateOptions{Sources: []string{"刀仔"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status(result, "刀仔") != RefreshUpdated || status(result, "武器大师") != RefreshSkipped || !result.Changed || result.TotalCount != 3+len(master) {
+		t.Fatalf("first run: %+v", result)
+	}
+	cache, err := cm.LoadEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept := filterBySource(cache.WeaponCodes, "武器大师"); hashWeaponCodes(kept) != hashWeaponCodes(master) {
+		t.Error("first run: 武器大师 entries were not kept")
+	}
+	for _, meta := range cache.SourceTable() {
+		if meta.Name == "刀仔" && (meta.FileHash == "" || meta.ParserVersion != excelParserVersion) {
+			t.Errorf("first run: 刀仔 metadata %+v", meta)
+		}
+	}
+
+	// The workbook did not change, so it is not parsed again
+	result, err = regenerateFromExcel(cm, GenerateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status(result, "刀仔") != RefreshUnchanged || status(result, "武器大师") != RefreshMissing || result.Changed {
+		t.Fatalf("second run: %+v", result)
+	}
+
+	result, err = regenerateFromExcel(cm, GenerateOptions{Sources: []string{"刀仔"}, Force: true})
+	if err != nil || status(result, "刀仔") != RefreshUpdated {
+		t.Fatalf("forced run: %+v, %v", result, err)
+	}
+
+	if _, err := regenerateFromExcel(cm, GenerateOptions{Sources: []string{"路人"}}); err == nil {
+		t.Error("unknown source accepted")
+	}
+}
+
+func TestMergeSourceUpdates(t *testing.T) {
+	existing := &WeaponCodeCache{WeaponCodes: testCodes(1)}
+	existing.Sources = buildSourceMeta(existing.WeaponCodes, nil, time.Now())
+	update := []WeaponCode{{ID: "new", Mode: "烽火地带", Name: "MP7", Code: testCode(50), Source: "武器大师"}}
+
+	codes, sources := mergeSourceUpdates(existing, map[string]sourceUpdate{
+		"武器大师": {Meta: SourceMeta{Name: "武器大师", FileHash: "sha256:new"}, Codes: update},
+	})
+	if len(codes) != 3 || codes[2].ID != "new" {
+		t.Errorf("codes: %+v", codes)
+	}
+	if len(sources) != 2 || sources[0].Name != "刀仔" || sources[1].FileHash != "sha256:new" {
+		t.Errorf("sources: %+v", sources)
+	}
+}
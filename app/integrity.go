@@ -0,0 +1,124 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Integrity states of a loaded cache
+const (
+	// IntegrityUnknown means the cache has not been loaded yet
+	IntegrityUnknown = "unknown"
+	// IntegrityVerified means the stored hashes match the content
+	IntegrityVerified = "verified"
+	// IntegrityUnverified means the cache carries no hash (written by an older version)
+	IntegrityUnverified = "unverified"
+	// IntegrityMismatch means the content was changed after it was saved
+	IntegrityMismatch = "mismatch"
+)
+
+// hashPrefix marks the algorithm used for content hashes
+const hashPrefix = "sha256:"
+
+// IntegrityReport describes the result of verifying a cache envelope
+type IntegrityReport struct {
+	Status       string `json:"status"`
+	ExpectedHash string `json:"expected_hash,omitempty"`
+	ActualHash   string `json:"actual_hash,omitempty"`
+	// MismatchedSources lists the sources whose per-source hash does not match
+	MismatchedSources []string `json:"mismatched_sources,omitempty"`
+}
+
+// Message returns a human readable summary of the report
+func (r IntegrityReport) Message() string {
+	switch r.Status {
+	case IntegrityVerified:
+		return "content hash verified"
+	case IntegrityUnverified:
+		return "cache has no content hash, integrity cannot be verified"
+	case IntegrityMismatch:
+		if len(r.MismatchedSources) > 0 {
+			return fmt.Sprintf("content hash mismatch (expected %s, got %s), modified sources: %v",
+				r.ExpectedHash, r.ActualHash, r.MismatchedSources)
+		}
+		return fmt.Sprintf("content hash mismatch (expected %s, got %s)", r.ExpectedHash, r.ActualHash)
+	default:
+		return "cache not loaded"
+	}
+}
+
+// hashWeaponCodes returns the SHA-256 content hash of a list of weapon codes
+// The hash covers the compact JSON encoding, so it does not depend on how
+// the cache file is indented.
+func hashWeaponCodes(codes []WeaponCode) string {
+	if codes == nil {
+		codes = []WeaponCode{}
+	}
+	data, err := json.Marshal(codes)
+	if err != nil {
+		// WeaponCode only holds strings and ints, so this cannot happen
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the SHA-256 hash of a file's content
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+// hashBytes returns the SHA-256 hash of data
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// hashSources returns the content hash of each data source's entries
+func hashSources(codes []WeaponCode) map[string]string {
+	bySource := make(map[string][]WeaponCode)
+	for _, code := range codes {
+		bySource[code.Source] = append(bySource[code.Source], code)
+	}
+
+	hashes := make(map[string]string, len(bySource))
+	for source, sourceCodes := range bySource {
+		hashes[source] = hashWeaponCodes(sourceCodes)
+	}
+	return hashes
+}
+
+// verifyCacheIntegrity checks the hashes stored in a cache envelope
+// against its weapon codes
+func verifyCacheIntegrity(cache *WeaponCodeCache) IntegrityReport {
+	if cache.ContentHash == "" {
+		return IntegrityReport{Status: IntegrityUnverified}
+	}
+
+	report := IntegrityReport{
+		Status:       IntegrityVerified,
+		ExpectedHash: cache.ContentHash,
+		ActualHash:   hashWeaponCodes(cache.WeaponCodes),
+	}
+
+	actualSources := hashSources(cache.WeaponCodes)
+	for _, meta := range cache.Sources {
+		if meta.ContentHash != "" && actualSources[meta.Name] != meta.ContentHash {
+			report.MismatchedSources = append(report.MismatchedSources, meta.Name)
+		}
+	}
+	sort.Strings(report.MismatchedSources)
+
+	if report.ActualHash != report.ExpectedHash || len(report.MismatchedSources) > 0 {
+		report.Status = IntegrityMismatch
+	}
+	return report
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// rewriteCache edits the envelope stored at path and writes it back as
+// indented JSON, the way someone editing the file by hand would
+func rewriteCache(t *testing.T, path string, edit func(*WeaponCodeCache)) {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err = maybeGunzip(raw); err != nil {
+		t.Fatal(err)
+	}
+	var cache WeaponCodeCache
+	if err := json.Unmarshal(raw, &cache); err != nil {
+		t.Fatal(err)
+	}
+	edit(&cache)
+	data, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CacheFileName)
+	cm := NewCacheManager(path)
+	if status := cm.Integrity().Status; status != IntegrityUnknown {
+		t.Errorf("before loading: %s", status)
+	}
+	if err := cm.Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+
+	// load reads the cache with a fresh manager and returns its report
+	load := func(step string) IntegrityReport {
+		t.Helper()
+		fresh := NewCacheManager(path)
+		codes, _, err := fresh.Load()
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if len(codes) != len(testCodes(1)) {
+			t.Fatalf("%s: loaded %d codes", step, len(codes))
+		}
+		return fresh.Integrity()
+	}
+
+	// Reformatting the file does not change the hash
+	rewriteCache(t, path, func(*WeaponCodeCache) {})
+	if report := load("reformatted"); report.Status != IntegrityVerified {
+		t.Errorf("reformatted: %s", report.Message())
+	}
+
+	// Changing a code is detected, down to the source, but still loads
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.WeaponCodes[1].Code = testCode(999)
+	})
+	report := load("tampered")
+	if report.Status != IntegrityMismatch || !reflect.DeepEqual(report.MismatchedSources, []string{"武器大师"}) {
+		t.Errorf("tampered: got %+v", report)
+	}
+
+	// Caches written before the hashes existed cannot be verified
+	rewriteCache(t, path, func(cache *WeaponCodeCache) {
+		cache.ContentHash = ""
+	})
+	if report := load("no hash"); report.Status != IntegrityUnverified {
+		t.Errorf("no hash: %s", report.Message())
+	}
+}
+
+func TestHashWeaponCodes(t *testing.T) {
+	if hashWeaponCodes(nil) != hashWeaponCodes([]WeaponCode{}) {
+		t.Error("nil and empty lists hash differently")
+	}
+	codes := testCodes(1)
+	hash := hashWeaponCodes(codes)
+	codes[0].Tier = "T1"
+	if hashWeaponCodes(codes) == hash {
+		t.Error("hash does not cover the tier")
+	}
+	if sources := hashSources(testCodes(1)); len(sources) != 2 || sources["刀仔"] == sources["武器大师"] {
+		t.Errorf("source hashes %v", sources)
+	}
+}
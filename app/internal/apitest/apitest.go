@@ -0,0 +1,237 @@
+// Package apitest runs a stand-in weapon code service for client tests: a
+// CodeServer over an in-memory cache behind a MockAPI, with a few more ways
+// to misbehave and counters of how it answered.
+package apitest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"delta-tool/app"
+)
+
+// Server is a stand-in weapon code service listening on a local port
+type Server struct {
+	*httptest.Server
+	// Mock plays the failure scenarios of the mock-api command
+	Mock *app.MockAPI
+
+	tb      testing.TB
+	cache   *app.CacheManager
+	codes   *app.CodeServer
+	handler http.Handler
+
+	mu sync.Mutex
+	// published lists the versions served so far, oldest first; forgotten
+	// ones are no longer answered with a delta
+	published []string
+	forgotten map[string]bool
+	sendETag  bool
+	// failures makes the next requests fail, see FailNext
+	failures   int
+	failStatus int
+	retryAfter string
+	stats      Stats
+}
+
+// Stats counts the weapon codes responses of a Server
+type Stats struct {
+	Requests    int
+	NotModified int
+	Deltas      int
+	// Failed counts the requests failed on purpose through FailNext or a
+	// failing scenario
+	Failed int
+}
+
+// NewServer starts a stand-in service serving codes; it is closed when
+// the test ends
+func NewServer(tb testing.TB, codes []app.WeaponCode) *Server {
+	tb.Helper()
+	s := &Server{
+		tb:        tb,
+		cache:     app.NewCacheManagerFromBytes(nil),
+		forgotten: make(map[string]bool),
+		sendETag:  true,
+	}
+	s.codes = app.NewCodeServer(s.cache)
+	s.Mock = app.NewMockAPI(s.codes)
+	s.handler = s.Mock.Handler()
+	s.SetCodes(codes)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// SetCodes publishes codes as a new version
+func (s *Server) SetCodes(codes []app.WeaponCode) {
+	s.tb.Helper()
+	if err := s.cache.Save(codes, "apitest"); err != nil {
+		s.tb.Fatalf("apitest: save codes: %v", err)
+	}
+	if _, err := s.codes.Reload(); err != nil {
+		s.tb.Fatalf("apitest: publish codes: %v", err)
+	}
+	version := s.Version()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = append(s.published, version)
+}
+
+// Version returns the version being served
+func (s *Server) Version() string {
+	rec := httptest.NewRecorder()
+	s.codes.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, app.HealthPath, nil))
+	var health app.HealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		s.tb.Fatalf("apitest: health: %v", err)
+	}
+	return health.Version
+}
+
+// ForgetHistory makes every version but the current one unknown, so
+// deltas against them are answered with the full list
+func (s *Server) ForgetHistory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, version := range s.published[:len(s.published)-1] {
+		s.forgotten[version] = true
+	}
+}
+
+// FailNext makes the next n requests fail with status, sending retryAfter
+// as the Retry-After header unless it is empty
+func (s *Server) FailNext(n, status int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.failStatus = status
+	s.retryAfter = retryAfter
+}
+
+// SetSendETag turns the ETag header on or off, to exercise
+// If-Modified-Since on its own
+func (s *Server) SetSendETag(send bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendETag = send
+}
+
+// Stats returns the response counters
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Failed += s.Mock.Failed()
+	return stats
+}
+
+// serveHTTP counts and alters weapon codes requests on their way to the
+// mock API; other endpoints are passed straight on
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != app.WeaponCodesPath {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Requests++
+	if s.failures > 0 {
+		s.failures--
+		s.stats.Failed++
+		status, retryAfter := s.failStatus, s.retryAfter
+		s.mu.Unlock()
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	query := r.URL.Query()
+	if s.forgotten[query.Get(app.SinceParam)] {
+		query.Del(app.SinceParam)
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+	}
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK, dropETag: !s.sendETag}
+	s.mu.Unlock()
+
+	s.handler.ServeHTTP(rec, r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case rec.status == http.StatusNotModified:
+		s.stats.NotModified++
+	case rec.isDelta():
+		s.stats.Deltas++
+	}
+}
+
+// recorder keeps a copy of a response on its way to the client
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	dropETag    bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.status = status
+		if r.dropETag {
+			r.Header().Del("ETag")
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// isDelta reports whether the response was a successful delta
+func (r *recorder) isDelta() bool {
+	if r.status != http.StatusOK {
+		return false
+	}
+	body := io.Reader(&r.body)
+	if r.Header().Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return false
+		}
+		defer zr.Close()
+		body = zr
+	}
+	var resp app.APIResponse
+	return json.NewDecoder(body).Decode(&resp) == nil && resp.Delta != nil
+}
+
+// Code returns a well-formed weapon code, distinct for every n
+func Code(n int) string {
+	return fmt.Sprintf("6APITEST%013d", n)
+}
+
+// Codes returns a small set of weapon codes from two sources; every
+// version has the same entries with different codes
+func Codes(version int) []app.WeaponCode {
+	return []app.WeaponCode{
+		{ID: "m4a1", Mode: "烽火地带", Name: "M4A1", Tier: "T0", Code: Code(version*10 + 1), Source: "刀仔"},
+		{ID: "ak-47", Mode: "全面战场", Name: "AK-47", Tier: "T1", Code: Code(version*10 + 2), Source: "武器大师"},
+		{ID: "m14", Mode: "烽火地带", Name: "M14", Tier: "T1", Code: Code(version*10 + 3), Source: "刀仔"},
+	}
+}
@@ -0,0 +1,308 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPath serves the server's metrics in the Prometheus text format
+const MetricsPath = "/metrics"
+
+// latencyBuckets are the upper bounds of the request duration histogram,
+// in seconds (the Prometheus client defaults)
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// serverMetrics counts what a CodeServer did since it started
+type serverMetrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	latency  map[string]*histogram
+	// published counts the versions served, reloadFailures the reloads
+	// that could not read the cache
+	published      uint64
+	reloadFailures uint64
+}
+
+// requestLabels identify one request counter
+type requestLabels struct {
+	endpoint string
+	method   string
+	code     int
+}
+
+// histogram counts observations per bucket of latencyBuckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests: make(map[requestLabels]uint64),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+// observeRequest records one finished request
+func (m *serverMetrics) observeRequest(endpoint, method string, code int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{endpoint, method, code}]++
+
+	h, ok := m.latency[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[endpoint] = h
+	}
+	seconds := took.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeReload records the outcome of a Reload
+func (m *serverMetrics) observeReload(changed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.reloadFailures++
+	} else if changed {
+		m.published++
+	}
+}
+
+// requestInfo is filled in while a request is handled, for its access log
+// line; guard records the name of the API key
+type requestInfo struct {
+	key string
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the requestInfo of r, or nil outside instrument
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// accessLogEntry is one line of the JSON access log
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Query      string  `json:"query,omitempty"`
+	Endpoint   string  `json:"endpoint"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Client     string  `json:"client"`
+	Key        string  `json:"key,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+// EnableAccessLog writes one JSON line per request to w
+func (s *CodeServer) EnableAccessLog(w io.Writer) {
+	s.accessLog = w
+}
+
+// instrument counts, times and logs every request handled by next
+func (s *CodeServer) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		took := time.Since(start)
+
+		// The mux has set the pattern by now; using it instead of the path
+		// keeps upload IDs and unknown paths out of the labels
+		endpoint := "other"
+		if r.Pattern != "" {
+			_, endpoint, _ = strings.Cut(r.Pattern, " ")
+			if endpoint == "" {
+				endpoint = r.Pattern
+			}
+		}
+		s.metrics.observeRequest(endpoint, metricMethod(r.Method), rec.status, took)
+
+		if s.accessLog == nil {
+			return
+		}
+		line, err := json.Marshal(accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Endpoint:   endpoint,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMS: float64(took.Microseconds()) / 1000,
+			Client:     clientIP(r),
+			Key:        info.key,
+			UserAgent:  r.UserAgent(),
+		})
+		if err != nil {
+			return
+		}
+		s.accessLogMu.Lock()
+		s.accessLog.Write(append(line, '\n'))
+		s.accessLogMu.Unlock()
+	})
+}
+
+// statusRecorder remembers the status code and body size written
+// through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes flushes on, so event streams keep working through it
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricMethod keeps made-up request methods out of the labels
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (s *CodeServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	s.writeMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// writeMetrics renders every metric of the server
+func (s *CodeServer) writeMetrics(b *strings.Builder) {
+	m := s.metrics
+	m.mu.Lock()
+	requests := make([]requestLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, c := requests[i], requests[j]
+		if a.endpoint != c.endpoint {
+			return a.endpoint < c.endpoint
+		}
+		if a.method != c.method {
+			return a.method < c.method
+		}
+		return a.code < c.code
+	})
+	metricHeader(b, "delta_tool_http_requests_total", "counter", "HTTP requests handled, by endpoint, method and status code.")
+	for _, labels := range requests {
+		fmt.Fprintf(b, "delta_tool_http_requests_total{endpoint=%s,method=%s,code=\"%d\"} %d\n",
+			labelValue(labels.endpoint), labelValue(labels.method), labels.code, m.requests[labels])
+	}
+
+	endpoints := make([]string, 0, len(m.latency))
+	for endpoint := range m.latency {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	metricHeader(b, "delta_tool_http_request_duration_seconds", "histogram", "Time taken to handle HTTP requests, by endpoint. Event streams count until they close.")
+	for _, endpoint := range endpoints {
+		h := m.latency[endpoint]
+		label := labelValue(endpoint)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(b, "delta_tool_http_request_duration_seconds_bucket{endpoint=%s,le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(b, "delta_tool_http_request_duration_seconds_bucket{endpoint=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(b, "delta_tool_http_request_duration_seconds_sum{endpoint=%s} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "delta_tool_http_request_duration_seconds_count{endpoint=%s} %d\n", label, h.count)
+	}
+
+	metricHeader(b, "delta_tool_versions_published_total", "counter", "Versions of the codes published since start.")
+	fmt.Fprintf(b, "delta_tool_versions_published_total %d\n", m.published)
+	metricHeader(b, "delta_tool_reload_failures_total", "counter", "Reloads that could not read the cache.")
+	fmt.Fprintf(b, "delta_tool_reload_failures_total %d\n", m.reloadFailures)
+	m.mu.Unlock()
+
+	current, _ := s.snapshot()
+	s.mu.RLock()
+	subscribers := len(s.subscribers)
+	s.mu.RUnlock()
+
+	if current != nil {
+		metricHeader(b, "delta_tool_served_version_info", "gauge", "Version of the codes being served.")
+		fmt.Fprintf(b, "delta_tool_served_version_info{version=%s} 1\n", labelValue(current.version))
+		metricHeader(b, "delta_tool_served_last_updated_timestamp_seconds", "gauge", "When the served codes were last updated, as a Unix time.")
+		fmt.Fprintf(b, "delta_tool_served_last_updated_timestamp_seconds %d\n", current.modified.Unix())
+
+		perSource := make(map[string]int)
+		for _, code := range current.codes {
+			perSource[code.Source]++
+		}
+		sources := make([]string, 0, len(perSource))
+		for source := range perSource {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		metricHeader(b, "delta_tool_served_entries", "gauge", "Entries being served, by data source.")
+		for _, source := range sources {
+			fmt.Fprintf(b, "delta_tool_served_entries{source=%s} %d\n", labelValue(source), perSource[source])
+		}
+	}
+
+	metricHeader(b, "delta_tool_event_subscribers", "gauge", "Open event streams.")
+	fmt.Fprintf(b, "delta_tool_event_subscribers %d\n", subscribers)
+	metricHeader(b, "delta_tool_uptime_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(b, "delta_tool_uptime_seconds %d\n", int64(time.Since(s.started).Seconds()))
+}
+
+// metricHeader writes the HELP and TYPE lines of a metric
+func metricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelValue quotes a label value as the text format wants it
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe for the server's goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestMetrics sends a few requests and checks what the metrics and the
+// access log say about them
+func TestMetrics(t *testing.T) {
+	server, served := newTestCodeServer(t, testCodes(1))
+	// A cache that cannot be read keeps the old version and is counted
+	if err := os.WriteFile(served.GetCachePath(), []byte("not a cache"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Reload(); err == nil {
+		t.Fatal("reload: broken cache was accepted")
+	}
+
+	var accessLog lockedBuffer
+	server.EnableAccessLog(&accessLog)
+	server.EnableAdmin("test-metrics-token")
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	get := func(path, key string) (string, int) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), resp.StatusCode
+	}
+	for _, path := range []string{WeaponCodesPath, WeaponCodesPath, WeaponCodesPath + "?" + LimitParam + "=many", HealthPath, "/nowhere"} {
+		get(path, "test-metrics-token")
+	}
+	metrics, status := get(MetricsPath, "")
+	if status != http.StatusOK {
+		t.Fatalf("metrics: status %d", status)
+	}
+
+	current, _ := server.snapshot()
+	for _, want := range []string{
+		`delta_tool_http_requests_total{endpoint="/api/weapon-codes",method="GET",code="200"} 2`,
+		`delta_tool_http_requests_total{endpoint="/api/weapon-codes",method="GET",code="400"} 1`,
+		`delta_tool_http_requests_total{endpoint="/healthz",method="GET",code="200"} 1`,
+		`delta_tool_http_requests_total{endpoint="other",method="GET",code="404"} 1`,
+		`delta_tool_http_request_duration_seconds_count{endpoint="/api/weapon-codes"} 3`,
+		`delta_tool_http_request_duration_seconds_bucket{endpoint="/api/weapon-codes",le="+Inf"} 3`,
+		`delta_tool_served_version_info{version="` + current.version + `"} 1`,
+		`delta_tool_served_entries{source="刀仔"} 2`,
+		`delta_tool_served_entries{source="武器大师"} 1`,
+		`delta_tool_versions_published_total 1`,
+		`delta_tool_reload_failures_total 1`,
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("metrics: missing %s", want)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("access log: %d lines, want 6", len(lines))
+	}
+	var first accessLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("access log: %v", err)
+	}
+	if first.Endpoint != WeaponCodesPath || first.Status != http.StatusOK || first.Key != adminTokenKeyName || first.Bytes == 0 {
+		t.Fatalf("access log: unexpected first line %s", lines[0])
+	}
+}
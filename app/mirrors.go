@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorsFileName lists extra API mirrors in the config directory,
+// one "URL [PRIORITY [API_KEY]]" per line
+const MirrorsFileName = "mirrors"
+
+// Mirror is one base URL serving the weapon code API
+type Mirror struct {
+	URL string
+	// Priority orders the mirrors; lower values are tried first
+	Priority int
+	// APIKey is sent to this mirror only (empty = none)
+	APIKey string
+}
+
+// MirrorStatus is the health of one mirror, as shown in GetCacheInfo
+type MirrorStatus struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	// Preferred marks the mirror that last succeeded
+	Preferred   bool          `json:"preferred"`
+	LastSuccess string        `json:"last_success,omitempty"`
+	LastFailure string        `json:"last_failure,omitempty"`
+	LastError   string        `json:"last_error,omitempty"`
+	Breaker     BreakerStatus `json:"breaker"`
+}
+
+// mirrorClient is a mirror with its API client and health record
+type mirrorClient struct {
+	Mirror
+	client *APIClient
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+// recordSuccess notes that the mirror answered
+func (m *mirrorClient) recordSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess = time.Now()
+	m.lastError = ""
+}
+
+// recordFailure notes that the mirror could not serve the codes
+func (m *mirrorClient) recordFailure(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastFailure = time.Now()
+	m.lastError = err.Error()
+}
+
+// coolingDown reports whether the mirror should only be tried after the
+// others: its circuit breaker is open, or its last request failed less
+// than cooldown ago
+func (m *mirrorClient) coolingDown(cooldown time.Duration, now time.Time) bool {
+	if status := m.client.BreakerStatus(); status.State == BreakerOpen {
+		if retryAt, err := parseCacheTime(status.RetryAt); err == nil && now.Before(retryAt) {
+			return true
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastFailure.After(m.lastSuccess) && now.Sub(m.lastFailure) < cooldown
+}
+
+// status returns the mirror's health record
+func (m *mirrorClient) status(preferred bool) MirrorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := MirrorStatus{
+		URL:       m.URL,
+		Priority:  m.Priority,
+		Preferred: preferred,
+		LastError: m.lastError,
+		Breaker:   m.client.BreakerStatus(),
+	}
+	if !m.lastSuccess.IsZero() {
+		status.LastSuccess = formatCacheTime(m.lastSuccess)
+	}
+	if !m.lastFailure.IsZero() {
+		status.LastFailure = formatCacheTime(m.lastFailure)
+	}
+	return status
+}
+
+// LoadMirrors returns the API mirrors from envValue (comma separated URLs,
+// tried in the order given, all using envKey) followed by those listed in
+// the config directory's mirrors file
+func LoadMirrors(envValue, envKey, configDir string) ([]Mirror, error) {
+	var mirrors []Mirror
+	for i, url := range strings.Split(envValue, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			mirrors = append(mirrors, Mirror{URL: url, Priority: i, APIKey: envKey})
+		}
+	}
+
+	path := filepath.Join(configDir, MirrorsFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mirrors, nil
+	}
+	if err != nil {
+		return mirrors, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		mirror := Mirror{URL: fields[0]}
+		if len(fields) > 1 {
+			priority, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return mirrors, fmt.Errorf("%s line %d: invalid priority %q", path, lineNum, fields[1])
+			}
+			mirror.Priority = priority
+		}
+		if len(fields) > 2 {
+			mirror.APIKey = fields[2]
+		}
+		mirrors = append(mirrors, mirror)
+	}
+
+	return mirrors, nil
+}
+
+// newMirrorClients creates a client per mirror, sorted by priority
+// Duplicate URLs are dropped; mirrors of equal priority keep their order.
+func newMirrorClients(mirrors []Mirror, retry RetryPolicy, breaker BreakerConfig) []*mirrorClient {
+	sorted := make([]Mirror, len(mirrors))
+	copy(sorted, mirrors)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	var clients []*mirrorClient
+	seen := make(map[string]bool)
+	for _, mirror := range sorted {
+		mirror.URL = strings.TrimRight(mirror.URL, "/")
+		if mirror.URL == "" || seen[mirror.URL] {
+			continue
+		}
+		seen[mirror.URL] = true
+		client := NewAPIClientWithPolicy(mirror.URL, retry, breaker)
+		client.SetAPIKey(mirror.APIKey)
+		clients = append(clients, &mirrorClient{Mirror: mirror, client: client})
+	}
+	return clients
+}
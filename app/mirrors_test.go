@@ -0,0 +1,96 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// TestMirrorFailover checks the failover order between two mirrors, that
+// a failed mirror is only asked again first once it has cooled down and
+// that the cache records which mirror served the codes
+func TestMirrorFailover(t *testing.T) {
+	primary := apitest.NewServer(t, apitest.Codes(1))
+	backup := apitest.NewServer(t, apitest.Codes(1))
+
+	const cooldown = 300 * time.Millisecond
+	cm := app.NewCacheManager(filepath.Join(t.TempDir(), app.CompressedCacheFileName))
+	loader := app.NewWeaponCodeLoaderWithConfig(cm, app.DataSourceConfig{
+		UseLocalCache: true,
+		// Listed backwards on purpose: the priorities decide the order
+		Mirrors: []app.Mirror{
+			{URL: backup.URL, Priority: 10},
+			{URL: primary.URL, Priority: 0},
+		},
+		CacheMaxAge: time.Hour,
+		Retry:       app.RetryPolicy{MaxAttempts: 1},
+		Breaker:     app.BreakerConfig{Cooldown: cooldown},
+	})
+	ctx := context.Background()
+
+	// servedBy checks which mirror the cache says served the codes
+	servedBy := func(step, want string) {
+		t.Helper()
+		if got := cm.EndpointValidators(app.WeaponCodesPath).Mirror; got != want {
+			t.Fatalf("%s: cache says codes came from %q, want %q", step, got, want)
+		}
+	}
+
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("healthy primary: %v", err)
+	}
+	servedBy("healthy primary", primary.URL)
+
+	// The primary fails, the backup takes over
+	primary.FailNext(100, http.StatusBadGateway, "")
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("failing primary: %v", err)
+	}
+	servedBy("failing primary", backup.URL)
+
+	// While the primary cools down the backup is asked first
+	before := primary.Stats().Requests
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("cooling primary: %v", err)
+	}
+	if primary.Stats().Requests != before {
+		t.Fatal("cooling primary: primary was asked before the backup")
+	}
+	if status := loader.MirrorStatus(); status[0].URL != backup.URL || !status[0].Preferred {
+		t.Fatal("cooling primary: backup is not first and preferred")
+	}
+
+	// A fresh loader picks the last good mirror up from the cache
+	restarted := app.NewWeaponCodeLoaderWithConfig(cm, app.DataSourceConfig{
+		UseLocalCache: true,
+		Mirrors:       []app.Mirror{{URL: primary.URL}, {URL: backup.URL, Priority: 10}},
+		Retry:         app.RetryPolicy{MaxAttempts: 1},
+	})
+	if status := restarted.MirrorStatus(); status[0].URL != primary.URL || !status[1].Preferred {
+		t.Fatalf("restart: want %s first and %s preferred, got %+v", primary.URL, backup.URL, status)
+	}
+
+	// Once it has cooled down, the recovered primary is asked first again
+	primary.FailNext(0, 0, "")
+	time.Sleep(cooldown)
+	before = backup.Stats().Requests
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("recovered primary: %v", err)
+	}
+	servedBy("recovered primary", primary.URL)
+	if backup.Stats().Requests != before {
+		t.Fatal("recovered primary: backup was asked before the primary")
+	}
+
+	// The backup goes away, nothing changes for the primary
+	backup.Close()
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("backup down: %v", err)
+	}
+	servedBy("backup down", primary.URL)
+}
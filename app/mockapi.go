@@ -0,0 +1,294 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockScriptPath lets a running mock API be rescripted: GET shows the
+// script, POST takes the next, default and delay query parameters
+const MockScriptPath = "/mock/script"
+
+// MockScenario is how a MockAPI answers one weapon codes request
+type MockScenario string
+
+// Mock scenarios
+const (
+	// ScenarioOK answers normally
+	ScenarioOK MockScenario = "ok"
+	// ScenarioSlow waits for the mock API's delay, then answers normally
+	ScenarioSlow MockScenario = "slow"
+	// ScenarioServerError answers 500 Internal Server Error
+	ScenarioServerError MockScenario = "500"
+	// ScenarioMalformedJSON answers 200 with a body that is not valid JSON
+	ScenarioMalformedJSON MockScenario = "malformed"
+	// ScenarioFailure answers 200 with success false and a message
+	ScenarioFailure MockScenario = "failure"
+	// ScenarioTruncated promises the whole body but drops the connection
+	// halfway through it
+	ScenarioTruncated MockScenario = "truncated"
+	// ScenarioVersionSkew answers ?since= with a delta against another
+	// version than the one asked for, like a mirror that lags behind
+	ScenarioVersionSkew MockScenario = "skew"
+)
+
+// mockScenarios lists every scenario, for parsing and usage text
+var mockScenarios = []MockScenario{
+	ScenarioOK, ScenarioSlow, ScenarioServerError, ScenarioMalformedJSON,
+	ScenarioFailure, ScenarioTruncated, ScenarioVersionSkew,
+}
+
+// DefaultMockDelay is how long ScenarioSlow waits unless told otherwise
+const DefaultMockDelay = 5 * time.Second
+
+// MockFailureMessage is the message of ScenarioFailure responses
+const MockFailureMessage = "mock failure: the data service is having a bad day"
+
+// ParseMockScenarios parses a comma-separated list of scenarios
+func ParseMockScenarios(list string) ([]MockScenario, error) {
+	var scenarios []MockScenario
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, scenario := range mockScenarios {
+			known = known || MockScenario(name) == scenario
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scenario %q (known: %s)", name, mockScenarioNames())
+		}
+		scenarios = append(scenarios, MockScenario(name))
+	}
+	return scenarios, nil
+}
+
+// mockScenarioNames returns the names of every scenario, comma-separated
+func mockScenarioNames() string {
+	names := make([]string, len(mockScenarios))
+	for i, scenario := range mockScenarios {
+		names[i] = string(scenario)
+	}
+	return strings.Join(names, ",")
+}
+
+// MockAPI plays failure scenarios in front of a CodeServer, so clients can
+// be tried against a data service that misbehaves on request. Requests are
+// answered normally until Script or SetScenario say otherwise.
+type MockAPI struct {
+	server *CodeServer
+
+	mu       sync.Mutex
+	script   []MockScenario
+	scenario MockScenario
+	delay    time.Duration
+	// failed counts the requests failed on purpose
+	failed int
+}
+
+// NewMockAPI puts a mock API in front of server
+func NewMockAPI(server *CodeServer) *MockAPI {
+	return &MockAPI{server: server, scenario: ScenarioOK, delay: DefaultMockDelay}
+}
+
+// Handler returns the server's handler with weapon codes requests played
+// through the scenarios, plus MockScriptPath
+func (m *MockAPI) Handler() http.Handler {
+	next := m.server.Handler()
+	mux := http.NewServeMux()
+	mux.Handle(WeaponCodesPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.handleWeaponCodes(w, r, next)
+	}))
+	mux.HandleFunc(MockScriptPath, m.handleMockScript)
+	mux.Handle("/", next)
+	return mux
+}
+
+// Script makes the next requests play scenarios, one request each, before
+// the default scenario applies again
+func (m *MockAPI) Script(scenarios ...MockScenario) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.script = append(m.script[:0:0], scenarios...)
+}
+
+// SetScenario sets the scenario played once the script has run out
+func (m *MockAPI) SetScenario(scenario MockScenario) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scenario = scenario
+}
+
+// SetDelay sets how long ScenarioSlow waits
+func (m *MockAPI) SetDelay(delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delay = delay
+}
+
+// Failed returns how many requests were failed on purpose
+func (m *MockAPI) Failed() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failed
+}
+
+// nextScenario takes the scenario of the next request
+func (m *MockAPI) nextScenario() MockScenario {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.script) > 0 {
+		scenario := m.script[0]
+		m.script = m.script[1:]
+		return scenario
+	}
+	return m.scenario
+}
+
+// handleWeaponCodes answers a weapon codes request the way the next
+// scenario says; scenarios that only delay or alter a normal answer hand
+// the request on to the server
+func (m *MockAPI) handleWeaponCodes(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	scenario := m.nextScenario()
+	current, history := m.server.snapshot()
+	if current == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	switch scenario {
+	case ScenarioSlow:
+		m.mu.Lock()
+		delay := m.delay
+		m.mu.Unlock()
+		if err := sleepContext(r.Context(), delay); err != nil {
+			return
+		}
+		next.ServeHTTP(w, r)
+		return
+
+	case ScenarioVersionSkew:
+		query := r.URL.Query()
+		if base, ok := skewedBase(current, history, query.Get(SinceParam)); ok {
+			query.Set(SinceParam, base)
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+		return
+
+	case ScenarioServerError:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+	case ScenarioMalformedJSON:
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success": true, "version": %q, "data": [{"id": `, current.version)
+
+	case ScenarioFailure:
+		writeJSON(w, http.StatusOK, APIResponse{Success: false, Message: MockFailureMessage})
+
+	case ScenarioTruncated:
+		// The server closes the connection when less than Content-Length is sent
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(current.body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(current.body[:len(current.body)/2])
+
+	default:
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	m.failed++
+	m.mu.Unlock()
+}
+
+// skewedBase returns the version a ScenarioVersionSkew delta is built
+// against instead of since: the version before it, or the one after it
+// when since is the oldest. It is false when there is no such version.
+func skewedBase(current *servedVersion, history []*servedVersion, since string) (string, bool) {
+	versions := append(history[:len(history):len(history)], current)
+	for i, v := range versions {
+		if v.version != since {
+			continue
+		}
+		switch {
+		case i > 0:
+			return versions[i-1].version, true
+		case len(versions) > 2:
+			// The current version would get the full list, not a delta
+			return versions[1].version, true
+		}
+	}
+	return "", false
+}
+
+// mockScript is the body of MockScriptPath
+type mockScript struct {
+	Next    []MockScenario `json:"next"`
+	Default MockScenario   `json:"default"`
+	Delay   string         `json:"delay"`
+	Version string         `json:"version"`
+}
+
+// handleMockScript shows or changes the script of the mock API
+func (m *MockAPI) handleMockScript(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := m.applyScript(r.URL.Query()); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	m.mu.Lock()
+	script := mockScript{
+		Next:    append([]MockScenario{}, m.script...),
+		Default: m.scenario,
+		Delay:   m.delay.String(),
+	}
+	m.mu.Unlock()
+	if current, _ := m.server.snapshot(); current != nil {
+		script.Version = current.version
+	}
+	writeJSON(w, http.StatusOK, script)
+}
+
+// applyScript takes the next, default and delay parameters of a POST to
+// MockScriptPath
+func (m *MockAPI) applyScript(query url.Values) error {
+	if query.Has("next") {
+		next, err := ParseMockScenarios(query.Get("next"))
+		if err != nil {
+			return err
+		}
+		m.Script(next...)
+	}
+	if query.Has("default") {
+		scenarios, err := ParseMockScenarios(query.Get("default"))
+		if err != nil || len(scenarios) != 1 {
+			return fmt.Errorf("default takes one of %s", mockScenarioNames())
+		}
+		m.SetScenario(scenarios[0])
+	}
+	if query.Has("delay") {
+		delay, err := time.ParseDuration(query.Get("delay"))
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid delay %q", query.Get("delay"))
+		}
+		m.SetDelay(delay)
+	}
+	return nil
+}
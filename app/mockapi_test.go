@@ -0,0 +1,143 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// TestMockScenarios loads codes while the mock API plays each failure
+// scenario and checks that the loader falls back to the cache, that a
+// skewed delta is replaced by a full fetch, and that without a cache the
+// failure is reported
+func TestMockScenarios(t *testing.T) {
+	server := apitest.NewServer(t, apitest.Codes(1))
+	server.Mock.SetDelay(2 * time.Second)
+
+	cm := app.NewCacheManager(filepath.Join(t.TempDir(), app.CompressedCacheFileName))
+	// newLoader gives every scenario a fresh circuit breaker
+	newLoader := func(cm *app.CacheManager) *app.WeaponCodeLoader {
+		return app.NewWeaponCodeLoaderWithConfig(cm, app.DataSourceConfig{
+			UseLocalCache: true,
+			APIBaseURL:    server.URL,
+			Retry:         app.RetryPolicy{MaxAttempts: 1},
+		})
+	}
+	if _, err := newLoader(cm).Load(context.Background()); err != nil {
+		t.Fatalf("prime cache: %v", err)
+	}
+
+	for _, scenario := range []app.MockScenario{app.ScenarioServerError, app.ScenarioMalformedJSON, app.ScenarioFailure, app.ScenarioTruncated, app.ScenarioSlow} {
+		server.Mock.Script(scenario)
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		start := time.Now()
+		codes, err := newLoader(cm).Load(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: no fallback to the cache: %v", scenario, err)
+		}
+		if !sameCodes(codes, apitest.Codes(1)) {
+			t.Fatalf("%s: loaded codes differ from the cache", scenario)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Fatalf("%s: fallback took %s", scenario, took.Round(time.Millisecond))
+		}
+	}
+	if failed := server.Stats().Failed; failed != 4 {
+		t.Errorf("%d requests failed on purpose, want 4", failed)
+	}
+
+	// A lagging mirror's delta does not apply; the full list is fetched
+	server.SetCodes(apitest.Codes(2))
+	server.SetCodes(apitest.Codes(3))
+	if _, _, err := newLoader(cm).Refresh(context.Background()); err != nil {
+		t.Fatalf("catch up: %v", err)
+	}
+	server.SetCodes(apitest.Codes(4))
+	server.Mock.Script(app.ScenarioVersionSkew)
+	before := server.Stats()
+	codes, _, err := newLoader(cm).Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("skew: %v", err)
+	}
+	if !sameCodes(codes, apitest.Codes(4)) {
+		t.Fatal("skew: loaded codes are not the current version")
+	}
+	if requests := server.Stats().Requests - before.Requests; requests != 2 {
+		t.Fatalf("skew: %d requests, want the delta and a full fetch", requests)
+	}
+
+	// Without a cache there is nothing to fall back to
+	server.Mock.Script(app.ScenarioFailure)
+	empty := app.NewCacheManager(filepath.Join(t.TempDir(), app.CompressedCacheFileName))
+	if _, err := newLoader(empty).Load(context.Background()); err == nil {
+		t.Fatal("no cache: failure was not reported")
+	}
+}
+
+func TestMockScript(t *testing.T) {
+	server := apitest.NewServer(t, apitest.Codes(1))
+
+	post := func(query string) (*http.Response, error) {
+		return http.Post(server.URL+app.MockScriptPath+"?"+query, "", nil)
+	}
+	resp, err := post("next=500,ok&default=failure&delay=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var script struct {
+		Next    []app.MockScenario `json:"next"`
+		Default app.MockScenario   `json:"default"`
+		Delay   string             `json:"delay"`
+		Version string             `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&script); err != nil {
+		t.Fatal(err)
+	}
+	if len(script.Next) != 2 || script.Default != app.ScenarioFailure || script.Delay != "3s" || script.Version != server.Version() {
+		t.Fatalf("script = %+v", script)
+	}
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK} {
+		resp, err := http.Get(server.URL + app.WeaponCodesPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("status %d, want %d", resp.StatusCode, want)
+		}
+	}
+	client := app.NewAPIClientWithPolicy(server.URL, app.RetryPolicy{MaxAttempts: 1}, app.BreakerConfig{})
+	if _, err := client.FetchWeaponCodes(context.Background()); err == nil {
+		t.Error("default scenario failure: fetch succeeded")
+	}
+
+	for _, bad := range []string{"next=teapot", "default=ok,500", "delay=soon"} {
+		resp, err := post(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", bad, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestParseMockScenarios(t *testing.T) {
+	scenarios, err := app.ParseMockScenarios(" 500, ok ,,skew")
+	if err != nil || len(scenarios) != 3 || scenarios[2] != app.ScenarioVersionSkew {
+		t.Errorf("got %v, %v", scenarios, err)
+	}
+	if _, err := app.ParseMockScenarios("ok,teapot"); err == nil {
+		t.Error("unknown scenario accepted")
+	}
+}
@@ -0,0 +1,399 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	// OpenAPIPath serves the OpenAPI document of the weapon code protocol
+	OpenAPIPath = "/openapi.json"
+	// ProtocolVersion is the version of the weapon code protocol in the
+	// OpenAPI document; bump it when a response type or parameter changes
+	ProtocolVersion = "1.0.0"
+)
+
+// apiParameter is a query parameter of the weapon codes endpoint
+type apiParameter struct {
+	name        string
+	schema      map[string]any
+	description string
+}
+
+// weaponCodesParameters lists the query parameters understood by the
+// weapon codes endpoint (FetchOptions and SinceParam)
+var weaponCodesParameters = []apiParameter{
+	{ModeParam, stringSchema(), "Game mode (烽火地带 or 全面战场)"},
+	{SourceParam, stringSchema(), "Data source (刀仔, 武器大师, ...)"},
+	{WeaponParam, stringSchema(), "Weapon names containing this text, ignoring case"},
+	{ClassParam, stringSchema(), "Weapon class (突击步枪, 冲锋枪, ...)"},
+	{TierParam, stringSchema(), "Tier, matched exactly"},
+	{MinPriceParam, integerSchema(), "Lowest build price (万); entries without a price are left out"},
+	{MaxPriceParam, integerSchema(), "Highest build price (万); entries without a price are left out"},
+	{UpdatedSinceParam, stringSchema(), "Entries updated on or after this date (RFC 3339 or YYYY-MM-DD)"},
+	{CursorParam, stringSchema(), "next_cursor of the previous page"},
+	{LimitParam, map[string]any{"type": "integer", "minimum": 1, "maximum": MaxPageLimit}, "Page size"},
+	{SinceParam, stringSchema(), "Version the client holds; answered with a delta when the server still knows it. Ignored when filtering."},
+}
+
+func stringSchema() map[string]any  { return map[string]any{"type": "string"} }
+func integerSchema() map[string]any { return map[string]any{"type": "integer"} }
+
+// schemaGenerator derives OpenAPI schemas from Go types through their
+// JSON encoding. Named structs become components referenced by $ref.
+type schemaGenerator struct {
+	components map[string]any
+}
+
+// schema returns the schema of values of type t
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.String:
+		return stringSchema()
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return integerSchema()
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+// structRef adds a struct to the components and returns a reference to it
+func (g *schemaGenerator) structRef(t reflect.Type) map[string]any {
+	ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	if _, ok := g.components[t.Name()]; ok {
+		return ref
+	}
+	// Register first so self-referencing types terminate
+	g.components[t.Name()] = nil
+
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		omitEmpty := strings.Contains(opts, "omitempty")
+
+		schema := g.schema(field.Type)
+		// encoding/json writes nil slices and maps as null
+		if !omitEmpty && (field.Type.Kind() == reflect.Slice || field.Type.Kind() == reflect.Map) {
+			schema = nullable(schema)
+		}
+		properties[name] = schema
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	g.components[t.Name()] = schema
+	return ref
+}
+
+// nullable marks a schema as also accepting null
+// References cannot carry siblings in OpenAPI 3.0, so they are wrapped.
+func nullable(schema map[string]any) map[string]any {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]any{"allOf": []any{schema}, "nullable": true}
+	}
+	out := make(map[string]any, len(schema)+1)
+	for k, v := range schema {
+		out[k] = v
+	}
+	out["nullable"] = true
+	return out
+}
+
+// OpenAPIDocument returns the OpenAPI 3 document of the weapon code
+// protocol, with the schemas generated from the Go types
+func OpenAPIDocument() map[string]any {
+	g := &schemaGenerator{components: map[string]any{}}
+	ref := func(v any) map[string]any { return g.schema(reflect.TypeOf(v)) }
+
+	jsonContent := func(schema map[string]any) map[string]any {
+		return map[string]any{"application/json": map[string]any{"schema": schema}}
+	}
+	response := func(description string, schema map[string]any) map[string]any {
+		return map[string]any{"description": description, "content": jsonContent(schema)}
+	}
+	apiResponse := ref(APIResponse{})
+	errorResponse := func(description string) map[string]any {
+		return response(description+" (success is false, message says why)", apiResponse)
+	}
+	rateLimited := map[string]any{
+		"description": "Too many requests; wait for Retry-After (success is false)",
+		"headers": map[string]any{
+			"Retry-After": map[string]any{"description": "Seconds to wait", "schema": integerSchema()},
+		},
+		"content": jsonContent(apiResponse),
+	}
+	readSecurity := []any{map[string]any{}, map[string]any{"apiKey": []any{}}, map[string]any{"bearer": []any{}}}
+	adminSecurity := []any{map[string]any{"apiKey": []any{}}, map[string]any{"bearer": []any{}}}
+
+	var parameters []any
+	for _, p := range weaponCodesParameters {
+		parameters = append(parameters, map[string]any{
+			"name":        p.name,
+			"in":          "query",
+			"required":    false,
+			"description": p.description,
+			"schema":      p.schema,
+		})
+	}
+	var feedParameters []any
+	for _, p := range parameters {
+		switch p.(map[string]any)["name"] {
+		case ModeParam, SourceParam, WeaponParam, ClassParam, TierParam, MinPriceParam, MaxPriceParam:
+			feedParameters = append(feedParameters, p)
+		}
+	}
+	validatorHeaders := []any{
+		map[string]any{"name": "If-None-Match", "in": "header", "required": false, "schema": stringSchema()},
+		map[string]any{"name": "If-Modified-Since", "in": "header", "required": false, "schema": stringSchema()},
+	}
+	uploadID := map[string]any{"name": "id", "in": "path", "required": true, "schema": stringSchema()}
+
+	paths := map[string]any{
+		WeaponCodesPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Weapon codes",
+				"description": "The full list, a delta against ?since=, or a filtered page when any filter or paging parameter is set. Full lists carry a strong ETag and Last-Modified and honour conditional requests; deltas carry a weak ETag of the same version and are always sent in full.",
+				"operationId": "getWeaponCodes",
+				"parameters":  append(parameters, validatorHeaders...),
+				"security":    readSecurity,
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Weapon codes",
+						"headers": map[string]any{
+							"ETag":          map[string]any{"schema": stringSchema()},
+							"Last-Modified": map[string]any{"schema": stringSchema()},
+						},
+						"content": jsonContent(apiResponse),
+					},
+					"304": map[string]any{"description": "Not modified since the validators sent"},
+					"400": errorResponse("Invalid parameter"),
+					"401": errorResponse("Missing or invalid API key"),
+					"429": rateLimited,
+					"503": errorResponse("No codes loaded yet"),
+				},
+			},
+		},
+		EventsPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Version change stream",
+				"description": "Server-Sent Events. Each \"" + VersionEventName + "\" event has the version as id and a VersionEvent as data. Reconnect with Last-Event-ID to hear about versions missed.",
+				"operationId": "streamEvents",
+				"parameters": []any{
+					map[string]any{"name": "Last-Event-ID", "in": "header", "required": false, "schema": stringSchema()},
+				},
+				"security": readSecurity,
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Event stream",
+						"content":     map[string]any{"text/event-stream": map[string]any{"schema": ref(VersionEvent{})}},
+					},
+					"401": errorResponse("Missing or invalid API key"),
+					"429": rateLimited,
+				},
+			},
+		},
+		HealthPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Health",
+				"operationId": "getHealth",
+				"responses": map[string]any{
+					"200": response("Serving codes", ref(HealthStatus{})),
+					"503": response("No codes loaded yet", ref(HealthStatus{})),
+				},
+			},
+		},
+		AdminUploadsPath: map[string]any{
+			"post": map[string]any{
+				"summary":     "Upload a creator workbook for preview",
+				"operationId": "uploadWorkbook",
+				"parameters": []any{
+					map[string]any{"name": SourceParam, "in": "query", "required": true, "schema": stringSchema()},
+				},
+				"requestBody": map[string]any{
+					"required": true,
+					"content": map[string]any{
+						"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": map[string]any{
+							"schema": map[string]any{"type": "string", "format": "binary"},
+						},
+						"multipart/form-data": map[string]any{
+							"schema": map[string]any{
+								"type":       "object",
+								"properties": map[string]any{"file": map[string]any{"type": "string", "format": "binary"}},
+							},
+						},
+					},
+				},
+				"security": adminSecurity,
+				"responses": map[string]any{
+					"201": response("Diff preview", ref(UploadPreview{})),
+					"400": errorResponse("Unknown source or empty upload"),
+					"401": errorResponse("Missing or invalid API key"),
+					"403": errorResponse("API key lacks the admin scope"),
+					"422": errorResponse("Workbook could not be parsed"),
+					"429": rateLimited,
+				},
+			},
+		},
+		AdminUploadsPath + "/{id}/publish": map[string]any{
+			"post": map[string]any{
+				"summary":     "Publish a previewed upload",
+				"operationId": "publishUpload",
+				"parameters": []any{
+					uploadID,
+					map[string]any{"name": "force", "in": "query", "required": false, "schema": map[string]any{"type": "boolean"}},
+				},
+				"security": adminSecurity,
+				"responses": map[string]any{
+					"200": response("Published", ref(PublishResult{})),
+					"401": errorResponse("Missing or invalid API key"),
+					"403": errorResponse("API key lacks the admin scope"),
+					"404": errorResponse("Unknown or expired upload"),
+					"409": errorResponse("Codes changed since the preview"),
+					"422": errorResponse("Preview has problems and force is not set"),
+					"429": rateLimited,
+				},
+			},
+		},
+		AdminUploadsPath + "/{id}": map[string]any{
+			"delete": map[string]any{
+				"summary":     "Discard a previewed upload",
+				"operationId": "discardUpload",
+				"parameters":  []any{uploadID},
+				"security":    adminSecurity,
+				"responses": map[string]any{
+					"204": map[string]any{"description": "Discarded"},
+					"401": errorResponse("Missing or invalid API key"),
+					"403": errorResponse("API key lacks the admin scope"),
+					"404": errorResponse("Unknown or expired upload"),
+					"429": rateLimited,
+				},
+			},
+		},
+		FeedPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Atom feed of added and changed codes",
+				"description": "One entry per code added or changed between the versions the server remembers, newest first. Filter with the same parameters as the weapon codes, e.g. ?weapon=M14 or ?source=刀仔.",
+				"operationId": "getFeed",
+				"parameters":  append(feedParameters, validatorHeaders...),
+				"security":    readSecurity,
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Atom feed",
+						"content":     map[string]any{"application/atom+xml": map[string]any{"schema": stringSchema()}},
+					},
+					"304": map[string]any{"description": "Not modified since the validators sent"},
+					"400": errorResponse("Invalid parameter"),
+					"401": errorResponse("Missing or invalid API key"),
+					"429": rateLimited,
+					"503": errorResponse("No codes loaded yet"),
+				},
+			},
+		},
+		MetricsPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "Metrics in the Prometheus text format",
+				"operationId": "getMetrics",
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Request counts and latencies per endpoint, the served version, entries per source and reload failures",
+						"content":     map[string]any{"text/plain": map[string]any{"schema": stringSchema()}},
+					},
+				},
+			},
+		},
+		OpenAPIPath: map[string]any{
+			"get": map[string]any{
+				"summary":     "This document",
+				"operationId": "getOpenAPI",
+				"responses": map[string]any{
+					"200": response("OpenAPI document", map[string]any{"type": "object"}),
+				},
+			},
+		},
+	}
+
+	// The signed data pack is three fixed files, served with serve --pack
+	for _, file := range []struct {
+		name, operationID string
+		response          map[string]any
+	}{
+		{PackManifestFile, "getPackManifest", response("Signed manifest of the data pack; conditional requests on it tell whether the pack changed", ref(PackManifest{}))},
+		{PackPayloadFile, "getPackPayload", response("Payload of the data pack, whose hash the manifest records", apiResponse)},
+		{PackSignatureFile, "getPackSignature", map[string]any{
+			"description": "Base64 ed25519 signature of the manifest",
+			"content":     map[string]any{"text/plain": map[string]any{"schema": stringSchema()}},
+		}},
+	} {
+		paths[DataPackPath+file.name] = map[string]any{
+			"get": map[string]any{
+				"summary":     "Data pack file " + file.name,
+				"operationId": file.operationID,
+				"parameters":  validatorHeaders,
+				"security":    readSecurity,
+				"responses": map[string]any{
+					"200": file.response,
+					"304": map[string]any{"description": "Not modified since the validators sent"},
+					"401": errorResponse("Missing or invalid API key"),
+					"404": errorResponse("The server has no signed data pack"),
+					"429": rateLimited,
+				},
+			},
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "delta-tool weapon code API",
+			"version":     ProtocolVersion,
+			"description": "Weapon codes served by delta-tool serve. API keys are only required when the server has an api_keys file.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document
+func (s *CodeServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPIDocument())
+}
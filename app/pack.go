@@ -0,0 +1,429 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// PackFormat is the current data pack format version
+	PackFormat = 1
+	// PackPayloadFile holds the APIResponse JSON of a data pack
+	PackPayloadFile = "payload.json"
+	// PackManifestFile describes the payload and is the signed document
+	PackManifestFile = "manifest.json"
+	// PackSignatureFile holds the base64 ed25519 signature of the manifest
+	PackSignatureFile = "manifest.sig"
+	// TrustedKeysFileName lists extra trusted public keys in the config directory
+	TrustedKeysFileName = "trusted_keys"
+)
+
+// TrustedPackKeys holds the public keys compiled into the binary, as a comma
+// separated list of base64 ed25519 keys. Release builds set it with:
+//
+//	go build -ldflags "-X delta-tool/app.TrustedPackKeys=<key1>,<key2>"
+var TrustedPackKeys = ""
+
+var (
+	// ErrPackUnsigned is returned when a data pack has no signature
+	ErrPackUnsigned = errors.New("data pack is not signed")
+	// ErrPackBadSignature is returned when no trusted key verifies the signature
+	ErrPackBadSignature = errors.New("data pack signature is not valid for any trusted key")
+	// ErrPackPayloadMismatch is returned when the payload does not match its manifest
+	ErrPackPayloadMismatch = errors.New("data pack payload does not match its manifest")
+	// ErrPackVersionMismatch is returned when the payload is not the version
+	// its manifest names
+	ErrPackVersionMismatch = errors.New("data pack payload version does not match its manifest")
+	// ErrPackReplayed is returned when a pack is older than the one already cached
+	ErrPackReplayed = errors.New("data pack is older than the cached one")
+	// ErrNoTrustedKeys is returned when a pack is verified without any trusted key
+	ErrNoTrustedKeys = errors.New("no trusted data pack keys configured")
+)
+
+// PackManifest describes the payload of a signed data pack
+type PackManifest struct {
+	Format        int    `json:"format"`
+	Version       string `json:"version"`
+	CreatedAt     string `json:"created_at"`
+	KeyID         string `json:"key_id"`
+	PayloadSHA256 string `json:"payload_sha256"`
+	PayloadSize   int    `json:"payload_size"`
+}
+
+// DataPack is a payload, its manifest and the detached manifest signature.
+// Manifest is kept as raw bytes because the signature covers them exactly.
+type DataPack struct {
+	Payload   []byte
+	Manifest  []byte
+	Signature []byte
+}
+
+// KeyID returns the short identifier of a public key used in manifests
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GeneratePackKey creates a new ed25519 signing key
+func GeneratePackKey() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return priv, nil
+}
+
+// EncodeKey returns the base64 form of a public or private key
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey decodes a base64 ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ReadPrivateKeyFile reads a base64 ed25519 private key written by WritePrivateKeyFile
+func ReadPrivateKeyFile(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file encoding: %w", err)
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(raw))
+	}
+	return ed25519.PrivateKey(raw), nil
+}
+
+// WritePrivateKeyFile stores a private key readable only by the current user
+func WritePrivateKeyFile(path string, key ed25519.PrivateKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(EncodeKey(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// LoadTrustedKeys returns the keys compiled into the binary plus those
+// listed in <configDir>/trusted_keys (one base64 key per line, # comments).
+// A trusted_keys file that lists no key is an error: it asks for signed
+// data, which no key could verify.
+func LoadTrustedKeys(configDir string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, encoded := range strings.Split(TrustedPackKeys, ",") {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		key, err := ParsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compiled-in trusted key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	path := filepath.Join(configDir, TrustedKeysFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	listed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParsePublicKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNum, err)
+		}
+		keys = append(keys, key)
+		listed++
+	}
+	if listed == 0 {
+		return nil, fmt.Errorf("%s lists no keys", path)
+	}
+
+	return keys, nil
+}
+
+// SignPack builds a data pack for payload and signs its manifest with key
+func SignPack(payload []byte, version string, key ed25519.PrivateKey) (*DataPack, error) {
+	sum := sha256.Sum256(payload)
+	manifest := PackManifest{
+		Format:        PackFormat,
+		Version:       version,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		KeyID:         KeyID(key.Public().(ed25519.PublicKey)),
+		PayloadSHA256: hex.EncodeToString(sum[:]),
+		PayloadSize:   len(payload),
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	return &DataPack{
+		Payload:   payload,
+		Manifest:  manifestData,
+		Signature: ed25519.Sign(key, manifestData),
+	}, nil
+}
+
+// VerifyPack checks the manifest signature against the trusted keys and
+// the payload, including its version, against the manifest. It returns the
+// verified manifest.
+func VerifyPack(pack *DataPack, trusted []ed25519.PublicKey) (*PackManifest, error) {
+	if len(trusted) == 0 {
+		return nil, ErrNoTrustedKeys
+	}
+	if len(pack.Signature) == 0 {
+		return nil, ErrPackUnsigned
+	}
+
+	verified := false
+	for _, key := range trusted {
+		if ed25519.Verify(key, pack.Manifest, pack.Signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrPackBadSignature
+	}
+
+	// The manifest is authentic from here on
+	var manifest PackManifest
+	if err := json.Unmarshal(pack.Manifest, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse pack manifest: %w", err)
+	}
+	if manifest.Format != PackFormat {
+		return nil, fmt.Errorf("unsupported data pack format %d", manifest.Format)
+	}
+
+	sum := sha256.Sum256(pack.Payload)
+	if manifest.PayloadSize != len(pack.Payload) || manifest.PayloadSHA256 != hex.EncodeToString(sum[:]) {
+		return nil, ErrPackPayloadMismatch
+	}
+
+	// The signature covers the manifest's version, not the payload's
+	var payload struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(pack.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse pack payload: %w", err)
+	}
+	if payload.Version != manifest.Version {
+		return nil, fmt.Errorf("%w: manifest %q, payload %q", ErrPackVersionMismatch, manifest.Version, payload.Version)
+	}
+
+	return &manifest, nil
+}
+
+// DecodePackPayload parses the APIResponse carried by a verified pack
+func DecodePackPayload(pack *DataPack) (*APIResponse, error) {
+	var apiResp APIResponse
+	if err := json.Unmarshal(pack.Payload, &apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse pack payload: %w", err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("pack payload error: %s", apiResp.Message)
+	}
+	return &apiResp, nil
+}
+
+// WritePackDir writes the three pack files to dir
+func WritePackDir(dir string, pack *DataPack) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	files := map[string][]byte{
+		PackPayloadFile:   pack.Payload,
+		PackManifestFile:  pack.Manifest,
+		PackSignatureFile: []byte(base64.StdEncoding.EncodeToString(pack.Signature) + "\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ReadPackDir reads a pack written by WritePackDir
+// A missing signature file yields a pack with an empty signature.
+func ReadPackDir(dir string) (*DataPack, error) {
+	payload, err := os.ReadFile(filepath.Join(dir, PackPayloadFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack payload: %w", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(dir, PackManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack manifest: %w", err)
+	}
+
+	pack := &DataPack{Payload: payload, Manifest: manifest}
+	sigData, err := os.ReadFile(filepath.Join(dir, PackSignatureFile))
+	if os.IsNotExist(err) {
+		return pack, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack signature: %w", err)
+	}
+
+	if pack.Signature, err = decodeSignature(sigData); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// decodeSignature decodes the base64 content of a manifest.sig file
+func decodeSignature(data []byte) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pack signature encoding: %w", err)
+	}
+	return sig, nil
+}
+
+// servedPack is a signed data pack as served at DataPackPath
+type servedPack struct {
+	files    map[string][]byte
+	manifest PackManifest
+	// created is the manifest's creation time, sent as Last-Modified
+	created time.Time
+}
+
+// loadServedPack reads a signed pack directory for serving
+// The signature is not verified, as the server need not trust the key,
+// but a pack without one is refused since no client would accept it.
+func loadServedPack(dir string) (*servedPack, error) {
+	pack, err := ReadPackDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(pack.Signature) == 0 {
+		return nil, fmt.Errorf("%s: %w", dir, ErrPackUnsigned)
+	}
+	served := &servedPack{
+		files: map[string][]byte{
+			PackPayloadFile:   pack.Payload,
+			PackManifestFile:  pack.Manifest,
+			PackSignatureFile: []byte(base64.StdEncoding.EncodeToString(pack.Signature) + "\n"),
+		},
+	}
+	if err := json.Unmarshal(pack.Manifest, &served.manifest); err != nil {
+		return nil, fmt.Errorf("%s: invalid pack manifest: %w", dir, err)
+	}
+	if created, err := parseCacheTime(served.manifest.CreatedAt); err == nil {
+		served.created = created.UTC().Truncate(time.Second)
+	}
+	return served, nil
+}
+
+// EnablePack serves the signed data pack in dir, as written by pack sign,
+// at DataPackPath. The pack cannot be re-signed here, so it is served as
+// is and picked up again on every Reload.
+func (s *CodeServer) EnablePack(dir string) error {
+	pack, err := loadServedPack(dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packDir = dir
+	s.setPackLocked(pack)
+	return nil
+}
+
+// reloadPack reads the pack directory again and serves it when the
+// manifest changed; a broken pack leaves the served one in place
+func (s *CodeServer) reloadPack() {
+	s.mu.RLock()
+	dir := s.packDir
+	s.mu.RUnlock()
+	if dir == "" {
+		return
+	}
+
+	pack, err := loadServedPack(dir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to reload data pack: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pack == nil || !bytes.Equal(s.pack.files[PackManifestFile], pack.files[PackManifestFile]) {
+		s.setPackLocked(pack)
+	}
+}
+
+// setPackLocked serves pack; callers hold s.mu
+func (s *CodeServer) setPackLocked(pack *servedPack) {
+	s.pack = pack
+	fmt.Printf("Serving data pack %s (created: %s, key: %s)\n",
+		pack.manifest.Version, pack.manifest.CreatedAt, pack.manifest.KeyID)
+}
+
+// handlePack serves the files of the signed data pack
+func (s *CodeServer) handlePack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	s.mu.RLock()
+	pack := s.pack
+	s.mu.RUnlock()
+	if pack == nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("this server has no signed data pack; fetch %s instead", WeaponCodesPath))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, DataPackPath)
+	data, ok := pack.files[name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no data pack file %q", name))
+		return
+	}
+
+	contentType := "application/json"
+	if name == PackSignatureFile {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+strings.TrimPrefix(hashBytes(data), hashPrefix)[:16]+`"`)
+	http.ServeContent(w, r, "", pack.created, bytes.NewReader(data))
+}
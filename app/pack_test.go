@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPack signs an APIResponse holding codes with key
+func testPack(t *testing.T, codes []WeaponCode, key ed25519.PrivateKey) *DataPack {
+	t.Helper()
+	payload, err := json.Marshal(APIResponse{Success: true, Version: "test", Data: codes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := SignPack(payload, "test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+// backdatePack re-signs pack as if it had been created at created
+func backdatePack(t *testing.T, pack *DataPack, created time.Time, key ed25519.PrivateKey) *DataPack {
+	t.Helper()
+	var manifest PackManifest
+	if err := json.Unmarshal(pack.Manifest, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	manifest.CreatedAt = formatCacheTime(created)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DataPack{Payload: pack.Payload, Manifest: data, Signature: ed25519.Sign(key, data)}
+}
+
+// testKey generates a signing key and returns it with its public key
+func testKey(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	t.Helper()
+	key, err := GeneratePackKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, key.Public().(ed25519.PublicKey)
+}
+
+func TestVerifyPack(t *testing.T) {
+	key, pub := testKey(t)
+	_, otherPub := testKey(t)
+	pack := testPack(t, testCodes(1), key)
+
+	manifest, err := VerifyPack(pack, []ed25519.PublicKey{otherPub, pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.KeyID != KeyID(pub) || manifest.PayloadSize != len(pack.Payload) {
+		t.Errorf("manifest %+v", manifest)
+	}
+
+	tampered := *pack
+	tampered.Payload = append([]byte{}, pack.Payload...)
+	tampered.Payload[len(tampered.Payload)-2] = ' '
+	unsigned := *pack
+	unsigned.Signature = nil
+	relabeled, err := SignPack(pack.Payload, "other", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		pack    *DataPack
+		trusted []ed25519.PublicKey
+		want    error
+	}{
+		{"no trusted keys", pack, nil, ErrNoTrustedKeys},
+		{"unsigned", &unsigned, []ed25519.PublicKey{pub}, ErrPackUnsigned},
+		{"other key", pack, []ed25519.PublicKey{otherPub}, ErrPackBadSignature},
+		{"tampered payload", &tampered, []ed25519.PublicKey{pub}, ErrPackPayloadMismatch},
+		{"other version", relabeled, []ed25519.PublicKey{pub}, ErrPackVersionMismatch},
+	} {
+		if _, err := VerifyPack(test.pack, test.trusted); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestPackDirRoundTrip(t *testing.T) {
+	key, pub := testKey(t)
+	pack := testPack(t, testCodes(1), key)
+	dir := filepath.Join(t.TempDir(), "pack")
+	if err := WritePackDir(dir, pack); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPackDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyPack(read, []ed25519.PublicKey{pub}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DecodePackPayload(read)
+	if err != nil || len(resp.Data) != len(testCodes(1)) {
+		t.Errorf("payload: %d codes, err %v", len(resp.Data), err)
+	}
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	_, pub := testKey(t)
+	dir := t.TempDir()
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, TrustedKeysFileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if keys, err := LoadTrustedKeys(dir); err != nil || len(keys) != 0 {
+		t.Errorf("no file: %d keys, err %v", len(keys), err)
+	}
+
+	write("# release key\n" + EncodeKey(pub) + "\n")
+	if keys, err := LoadTrustedKeys(dir); err != nil || len(keys) != 1 || !keys[0].Equal(pub) {
+		t.Errorf("one key: %d keys, err %v", len(keys), err)
+	}
+
+	// Key material that cannot be used must not turn signing off
+	for _, content := range []string{"# keys go here\n", EncodeKey(pub) + "\nnot-a-key\n"} {
+		write(content)
+		if keys, err := LoadTrustedKeys(dir); err == nil {
+			t.Errorf("%q: accepted with %d keys", content, len(keys))
+		}
+		paths := testPaths(t)
+		paths.ConfigDir = dir
+		if _, err := NewDataLayer(paths); err == nil {
+			t.Errorf("%q: data layer built without the trusted keys", content)
+		}
+	}
+}
+
+// TestLoaderRequiresSignedPacks fetches packs with trusted keys configured
+func TestLoaderRequiresSignedPacks(t *testing.T) {
+	key, pub := testKey(t)
+	otherKey, _ := testKey(t)
+	packDir := t.TempDir()
+	ts := httptest.NewServer(http.StripPrefix(DataPackPath, http.FileServer(http.Dir(packDir))))
+	defer ts.Close()
+
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	loader := NewWeaponCodeLoaderWithConfig(cm, DataSourceConfig{
+		UseLocalCache: true,
+		APIBaseURL:    ts.URL,
+		TrustedKeys:   []ed25519.PublicKey{pub},
+	})
+	published := 0
+	publish := func(pack *DataPack) {
+		t.Helper()
+		os.RemoveAll(packDir)
+		if err := WritePackDir(packDir, pack); err != nil {
+			t.Fatal(err)
+		}
+		// Every pack is newer than the last, at the second precision of
+		// Last-Modified
+		published++
+		modified := time.Now().Add(time.Duration(published) * time.Hour)
+		if err := os.Chtimes(filepath.Join(packDir, PackManifestFile), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := testPack(t, testCodes(1), key)
+	publish(first)
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatalf("signed pack: %v", err)
+	}
+
+	// An older, validly signed pack must not roll the cache back
+	replayed := backdatePack(t, testPack(t, testCodes(2), key), time.Now().Add(-time.Hour), key)
+	publish(replayed)
+	if _, _, err := loader.Refresh(context.Background()); !errors.Is(err, ErrPackReplayed) {
+		t.Errorf("replayed pack: got %v, want %v", err, ErrPackReplayed)
+	}
+
+	// Nor after a local save (admin publish, generate-cache) or a 304
+	// check has rewritten the cache
+	if err := cm.Save(testCodes(1), "admin-upload"); err != nil {
+		t.Fatal(err)
+	}
+	publish(first)
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatalf("republished pack: %v", err)
+	}
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatalf("unchanged pack: %v", err)
+	}
+	if err := cm.Save(testCodes(1), "local-excel"); err != nil {
+		t.Fatal(err)
+	}
+	publish(replayed)
+	if _, _, err := loader.Refresh(context.Background()); !errors.Is(err, ErrPackReplayed) {
+		t.Errorf("replayed pack after save: got %v, want %v", err, ErrPackReplayed)
+	}
+
+	unsigned := testPack(t, testCodes(2), key)
+	unsigned.Signature = nil
+	for name, pack := range map[string]*DataPack{
+		"unsigned":  unsigned,
+		"other key": testPack(t, testCodes(2), otherKey),
+	} {
+		publish(pack)
+		if _, _, err := loader.Refresh(context.Background()); err == nil {
+			t.Errorf("%s: pack accepted", name)
+		}
+		codes, _, err := cm.Load()
+		if err != nil || hashWeaponCodes(codes) != hashWeaponCodes(testCodes(1)) {
+			t.Errorf("%s: cache replaced (err %v)", name, err)
+		}
+	}
+
+	// A newer pack is taken as usual
+	publish(testPack(t, testCodes(3), key))
+	if _, _, err := loader.Refresh(context.Background()); err != nil {
+		t.Fatalf("newer pack: %v", err)
+	}
+}
+
+// TestServePack has a loader that requires signed packs refresh from a
+// CodeServer, with and without a pack to serve
+func TestServePack(t *testing.T) {
+	key, pub := testKey(t)
+	server, _ := newTestCodeServer(t, testCodes(1))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := cm.Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+	loader := NewWeaponCodeLoaderWithConfig(cm, DataSourceConfig{
+		UseLocalCache: true,
+		APIBaseURL:    ts.URL,
+		TrustedKeys:   []ed25519.PublicKey{pub},
+	})
+
+	// Without a pack the refresh fails and the cache is still served
+	if _, _, err := loader.Refresh(context.Background()); !errors.Is(err, errPackFileNotFound) {
+		t.Errorf("no pack: got %v, want %v", err, errPackFileNotFound)
+	}
+	if codes, err := loader.Load(context.Background()); err != nil || hashWeaponCodes(codes) != hashWeaponCodes(testCodes(1)) {
+		t.Errorf("no pack: cache not served (err %v)", err)
+	}
+
+	packDir := t.TempDir()
+	unsigned := testPack(t, testCodes(2), key)
+	unsigned.Signature = nil
+	if err := WritePackDir(packDir, unsigned); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.EnablePack(packDir); !errors.Is(err, ErrPackUnsigned) {
+		t.Fatalf("unsigned pack: got %v, want %v", err, ErrPackUnsigned)
+	}
+
+	if err := WritePackDir(packDir, testPack(t, testCodes(2), key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.EnablePack(packDir); err != nil {
+		t.Fatal(err)
+	}
+	codes, changed, err := loader.Refresh(context.Background())
+	if err != nil || !changed || hashWeaponCodes(codes) != hashWeaponCodes(testCodes(2)) {
+		t.Fatalf("served pack: %d codes, changed %v, err %v", len(codes), changed, err)
+	}
+	if _, changed, err := loader.Refresh(context.Background()); err != nil || changed {
+		t.Errorf("unchanged pack: changed %v, err %v", changed, err)
+	}
+
+	// A newly signed pack is picked up on reload
+	time.Sleep(1100 * time.Millisecond)
+	if err := WritePackDir(packDir, testPack(t, testCodes(3), key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if codes, _, err := loader.Refresh(context.Background()); err != nil || hashWeaponCodes(codes) != hashWeaponCodes(testCodes(3)) {
+		t.Errorf("re-signed pack: err %v", err)
+	}
+}
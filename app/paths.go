@@ -0,0 +1,360 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// AppDirName is the directory created under the platform storage roots
+	AppDirName = "delta-tool"
+	// HomeEnvVar points every writable location at a single directory
+	HomeEnvVar = "DELTA_TOOL_HOME"
+	// DataDirFlag is the command line flag with the same effect as HomeEnvVar
+	DataDirFlag = "--data-dir"
+	// PortableFlag enables portable mode from the command line
+	PortableFlag = "--portable"
+	// PortableMarkerFile enables portable mode when placed next to the executable
+	PortableMarkerFile = "portable.flag"
+	// ReadOnlyFlag serves the embedded data from memory without writing any file
+	ReadOnlyFlag = "--read-only"
+)
+
+// StorageOptions holds the command line settings that affect where data is stored
+type StorageOptions struct {
+	// DataDir overrides DELTA_TOOL_HOME and the platform defaults (empty = unset)
+	DataDir string
+	// Portable keeps all state next to the executable, like PortableMarkerFile
+	Portable bool
+	// ReadOnly serves the embedded data from memory and never writes to disk
+	ReadOnly bool
+}
+
+// StoragePaths lists every resolved storage location.
+// BundledDir holds the read-only data shipped with the application,
+// while ConfigDir, DataDir and CacheDir hold writable user data.
+type StoragePaths struct {
+	ConfigDir  string
+	DataDir    string
+	CacheDir   string
+	BundledDir string
+	// ResolvedFrom tells how the writable locations were chosen:
+	// "portable", "flag", "env" or "platform"
+	ResolvedFrom string
+	// Portable is true when every location is under the executable directory
+	Portable bool
+	// ReadOnly is true when the cache is served from memory only
+	ReadOnly bool
+}
+
+// CacheFile returns the writable weapon codes cache file
+func (p StoragePaths) CacheFile() string {
+	return filepath.Join(p.DataDir, CompressedCacheFileName)
+}
+
+// PreviousCacheFiles returns where releases before the configurable
+// storage locations kept the plain JSON cache, so it can be moved to
+// CacheFile once. Only the platform defaults replace those locations;
+// an explicit data directory or portable mode leaves them alone.
+func (p StoragePaths) PreviousCacheFiles() []string {
+	if p.ResolvedFrom != "platform" {
+		return nil
+	}
+	return previousCacheFiles()
+}
+
+// BundledCacheFile returns the read-only weapon codes file shipped with the app
+func (p StoragePaths) BundledCacheFile() string {
+	return filepath.Join(p.BundledDir, CompressedCacheFileName)
+}
+
+// BundledExportFile returns the plain JSON copy of the bundled data,
+// kept next to it for human inspection
+func (p StoragePaths) BundledExportFile() string {
+	return filepath.Join(p.BundledDir, CacheFileName)
+}
+
+// QuarantineFile returns where the last dataset rejected by the refresh
+// validation is kept
+func (p StoragePaths) QuarantineFile() string {
+	return filepath.Join(p.DataDir, QuarantineFileName)
+}
+
+// Map returns the paths keyed by name, for display and GetCacheInfo
+func (p StoragePaths) Map() map[string]string {
+	return map[string]string{
+		"config_dir":    p.ConfigDir,
+		"data_dir":      p.DataDir,
+		"cache_dir":     p.CacheDir,
+		"bundled_dir":   p.BundledDir,
+		"cache_file":    p.CacheFile(),
+		"bundled_file":  p.BundledCacheFile(),
+		"bundled_json":  p.BundledExportFile(),
+		"quarantine":    p.QuarantineFile(),
+		"resolved_from": p.ResolvedFrom,
+		"portable":      fmt.Sprintf("%t", p.Portable),
+		"read_only":     fmt.Sprintf("%t", p.ReadOnly),
+	}
+}
+
+// ParseStorageFlags extracts the storage flags from the command line.
+// It accepts "--data-dir DIR", "--data-dir=DIR", "--portable" and
+// "--read-only" anywhere in args and returns the remaining arguments unchanged.
+func ParseStorageFlags(args []string) (StorageOptions, []string, error) {
+	var opts StorageOptions
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == DataDirFlag:
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("%s requires a directory", DataDirFlag)
+			}
+			opts.DataDir = args[i+1]
+			i++
+		case strings.HasPrefix(arg, DataDirFlag+"="):
+			opts.DataDir = strings.TrimPrefix(arg, DataDirFlag+"=")
+		case arg == PortableFlag:
+			opts.Portable = true
+		case arg == ReadOnlyFlag:
+			opts.ReadOnly = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	return opts, rest, nil
+}
+
+// ResolveStoragePaths resolves every storage location.
+// Writable locations are chosen in this order:
+// 1. Portable mode (--portable flag or portable.flag next to the executable)
+// 2. --data-dir flag
+// 3. DELTA_TOOL_HOME environment variable
+// 4. Platform defaults (XDG_CONFIG_HOME, XDG_DATA_HOME and XDG_CACHE_HOME are honored outside Windows)
+func ResolveStoragePaths(opts StorageOptions) (StoragePaths, error) {
+	exeDir := executableDir()
+	if opts.Portable || portableMarkerExists(exeDir) {
+		if opts.DataDir != "" {
+			return StoragePaths{}, fmt.Errorf("%s cannot be combined with portable mode", DataDirFlag)
+		}
+		return resolvePortablePaths(exeDir, opts.ReadOnly)
+	}
+
+	paths := StoragePaths{
+		BundledDir: findBundledDir(),
+		ReadOnly:   opts.ReadOnly,
+	}
+
+	root, resolvedFrom := opts.DataDir, "flag"
+	if root == "" {
+		root, resolvedFrom = os.Getenv(HomeEnvVar), "env"
+	}
+
+	if root != "" {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return paths, fmt.Errorf("failed to resolve data directory %s: %w", root, err)
+		}
+		paths.ConfigDir = absRoot
+		paths.DataDir = absRoot
+		paths.CacheDir = filepath.Join(absRoot, "cache")
+		paths.ResolvedFrom = resolvedFrom
+		return paths, nil
+	}
+
+	paths.ConfigDir, paths.DataDir, paths.CacheDir = platformDirs()
+	paths.ResolvedFrom = "platform"
+	return paths, nil
+}
+
+// resolvePortablePaths places every location, including the bundled data,
+// under <exeDir>/data so nothing is read from or written to the home directory
+func resolvePortablePaths(exeDir string, readOnly bool) (StoragePaths, error) {
+	dataDir := filepath.Join(exeDir, "data")
+	paths := StoragePaths{
+		ConfigDir:    dataDir,
+		DataDir:      dataDir,
+		CacheDir:     filepath.Join(dataDir, "cache"),
+		BundledDir:   dataDir,
+		ResolvedFrom: "portable",
+		Portable:     true,
+		ReadOnly:     readOnly,
+	}
+
+	// A read-only portable run never writes, so the directory may be read-only
+	if readOnly {
+		return paths, nil
+	}
+
+	if err := ensureWritableDir(dataDir); err != nil {
+		return paths, fmt.Errorf("portable mode needs a writable directory next to the executable, "+
+			"but %s is read-only (copy delta-tool to a writable location, remove %s or use %s): %w",
+			dataDir, PortableMarkerFile, ReadOnlyFlag, err)
+	}
+
+	return paths, nil
+}
+
+// portableMarkerExists reports whether portable.flag sits next to the executable
+func portableMarkerExists(exeDir string) bool {
+	_, err := os.Stat(filepath.Join(exeDir, PortableMarkerFile))
+	return err == nil
+}
+
+// ensureWritableDir creates dir if needed and checks that files can be written to it
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// platformDirs returns the default config, data and cache directories
+// On Windows: %APPDATA%\delta-tool for settings, %LOCALAPPDATA%\delta-tool for data
+// On macOS: ~/Library/Application Support/delta-tool and ~/Library/Caches/delta-tool
+// On Linux: $XDG_CONFIG_HOME, $XDG_DATA_HOME and $XDG_CACHE_HOME, defaulting to
+// ~/.config, ~/.local/share and ~/.cache
+func platformDirs() (configDir, dataDir, cacheDir string) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		// Fallback to executable directory if home dir not available
+		exeDir := executableDir()
+		return exeDir, exeDir, filepath.Join(exeDir, "cache")
+	}
+
+	if runtime.GOOS == "windows" {
+		return windowsDirs(homeDir)
+	}
+
+	var defaultConfig, defaultData, defaultCache string
+	switch runtime.GOOS {
+	case "darwin":
+		appSupport := filepath.Join(homeDir, "Library", "Application Support")
+		defaultConfig = appSupport
+		defaultData = appSupport
+		defaultCache = filepath.Join(homeDir, "Library", "Caches")
+	default: // Linux and others (freebsd, openbsd, etc.)
+		defaultConfig = filepath.Join(homeDir, ".config")
+		defaultData = filepath.Join(homeDir, ".local", "share")
+		defaultCache = filepath.Join(homeDir, ".cache")
+	}
+
+	configDir = filepath.Join(xdgDir("XDG_CONFIG_HOME", defaultConfig), AppDirName)
+	dataDir = filepath.Join(xdgDir("XDG_DATA_HOME", defaultData), AppDirName)
+	cacheDir = filepath.Join(xdgDir("XDG_CACHE_HOME", defaultCache), AppDirName)
+	return configDir, dataDir, cacheDir
+}
+
+// windowsDirs returns the default directories on Windows. Settings roam
+// with the profile, while the cache stays on the machine; the install
+// directory (BundledDir) is usually not writable.
+func windowsDirs(homeDir string) (configDir, dataDir, cacheDir string) {
+	roaming := os.Getenv("APPDATA")
+	if roaming == "" {
+		roaming = filepath.Join(homeDir, "AppData", "Roaming")
+	}
+	local := os.Getenv("LOCALAPPDATA")
+	if local == "" {
+		local = filepath.Join(homeDir, "AppData", "Local")
+	}
+	dataDir = filepath.Join(local, AppDirName)
+	return filepath.Join(roaming, AppDirName), dataDir, filepath.Join(dataDir, "cache")
+}
+
+// previousCacheFiles returns the cache locations of earlier releases
+// On Windows: <InstallDir>\data\weapon_codes.json
+// On macOS: ~/Library/Application Support/delta-tool/weapon_codes.json
+// On Linux: ~/.config/delta-tool/weapon_codes.json (XDG variables were ignored)
+func previousCacheFiles() []string {
+	if runtime.GOOS == "windows" {
+		return []string{filepath.Join(executableDir(), "data", CacheFileName)}
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	if runtime.GOOS == "darwin" {
+		return []string{filepath.Join(homeDir, "Library", "Application Support", AppDirName, CacheFileName)}
+	}
+	return []string{filepath.Join(homeDir, ".config", AppDirName, CacheFileName)}
+}
+
+// xdgDir returns the value of an XDG base directory variable, or fallback
+// when it is unset. Relative paths are invalid per the XDG spec and ignored.
+func xdgDir(envVar, fallback string) string {
+	dir := os.Getenv(envVar)
+	if dir == "" || !filepath.IsAbs(dir) {
+		return fallback
+	}
+	return dir
+}
+
+// findBundledDir returns the directory holding the data shipped with the app
+// It checks multiple locations: local data dir, executable dir, etc.
+func findBundledDir() string {
+	possibleDirs := []string{"data", "."}
+
+	// Check executable directory
+	if exePath, err := os.Executable(); err == nil {
+		exeDir := filepath.Dir(exePath)
+		possibleDirs = append(possibleDirs,
+			exeDir,
+			filepath.Join(exeDir, "data"),
+			filepath.Join(filepath.Dir(exeDir), "data"),
+		)
+	}
+
+	// Return first directory containing the cache file, or default to data dir
+	for _, dir := range possibleDirs {
+		for _, name := range []string{CompressedCacheFileName, CacheFileName} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir
+			}
+		}
+	}
+
+	// Default to data directory in current working directory
+	return "data"
+}
+
+// isInDir reports whether path is dir or lies below it
+// Both are compared as cleaned absolute paths, so "data" and an absolute
+// path to the same directory match. When either cannot be resolved the
+// answer is true, as callers use it to protect files from removal.
+func isInDir(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// executableDir returns the directory of the running executable
+func executableDir() string {
+	exePath, err := os.Executable()
+	if err != nil {
+		// Last resort: current working directory
+		return "."
+	}
+	return filepath.Dir(exePath)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseStorageFlags(t *testing.T) {
+	opts, rest, err := ParseStorageFlags([]string{"serve", "--data-dir", "/srv/delta", "--addr", ":9000", "--read-only"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StorageOptions{DataDir: "/srv/delta", ReadOnly: true}
+	if opts != want || !reflect.DeepEqual(rest, []string{"serve", "--addr", ":9000"}) {
+		t.Errorf("got %+v %v", opts, rest)
+	}
+
+	opts, rest, err = ParseStorageFlags([]string{"--data-dir=/tmp/x", "--portable"})
+	if err != nil || opts.DataDir != "/tmp/x" || !opts.Portable || len(rest) != 0 {
+		t.Errorf("got %+v %v %v", opts, rest, err)
+	}
+
+	if _, _, err := ParseStorageFlags([]string{"paths", "--data-dir"}); err == nil {
+		t.Error("--data-dir without a directory accepted")
+	}
+}
+
+func TestResolveStoragePathsOrder(t *testing.T) {
+	flagDir, envDir := t.TempDir(), t.TempDir()
+	t.Setenv(HomeEnvVar, envDir)
+
+	paths, err := ResolveStoragePaths(StorageOptions{DataDir: flagDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths.ResolvedFrom != "flag" || paths.DataDir != flagDir || paths.ConfigDir != flagDir || paths.CacheDir != filepath.Join(flagDir, "cache") {
+		t.Errorf("flag: got %+v", paths)
+	}
+
+	paths, err = ResolveStoragePaths(StorageOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths.ResolvedFrom != "env" || paths.DataDir != envDir || !paths.ReadOnly {
+		t.Errorf("env: got %+v", paths)
+	}
+	if paths.CacheFile() != filepath.Join(envDir, CompressedCacheFileName) {
+		t.Errorf("env: cache file %s", paths.CacheFile())
+	}
+
+	if _, err := ResolveStoragePaths(StorageOptions{DataDir: flagDir, Portable: true}); err == nil {
+		t.Error("--data-dir combined with --portable accepted")
+	}
+}
+
+func TestResolveStoragePathsXDG(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG directories are only used on Linux and other unixes")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(HomeEnvVar, "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg-config"))
+	t.Setenv("XDG_DATA_HOME", "relative/data")
+	t.Setenv("XDG_CACHE_HOME", "")
+
+	paths, err := ResolveStoragePaths(StorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StoragePaths{
+		ConfigDir: filepath.Join(home, "xdg-config", AppDirName),
+		// Relative XDG paths are invalid and ignored
+		DataDir:      filepath.Join(home, ".local", "share", AppDirName),
+		CacheDir:     filepath.Join(home, ".cache", AppDirName),
+		BundledDir:   paths.BundledDir,
+		ResolvedFrom: "platform",
+	}
+	if paths != want {
+		t.Errorf("got %+v, want %+v", paths, want)
+	}
+}
+
+func TestResolvePortablePaths(t *testing.T) {
+	exeDir := t.TempDir()
+	paths, err := resolvePortablePaths(exeDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(exeDir, "data")
+	for name, dir := range map[string]string{
+		"config": paths.ConfigDir, "data": paths.DataDir, "bundled": paths.BundledDir,
+		"cache": filepath.Dir(paths.CacheDir), "cache file": filepath.Dir(paths.CacheFile()),
+	} {
+		if dir != dataDir {
+			t.Errorf("%s dir %s is not under %s", name, dir, dataDir)
+		}
+	}
+	if !paths.Portable || paths.ResolvedFrom != "portable" {
+		t.Errorf("got %+v", paths)
+	}
+	if info, err := os.Stat(dataDir); err != nil || !info.IsDir() {
+		t.Errorf("data dir not created: %v", err)
+	}
+}
+
+func TestResolvePortablePathsNotWritable(t *testing.T) {
+	exeDir := t.TempDir()
+	// A file where the data directory should be makes it unusable, even as root
+	if err := os.WriteFile(filepath.Join(exeDir, "data"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolvePortablePaths(exeDir, false); err == nil {
+		t.Error("unwritable portable directory accepted")
+	}
+
+	// A read-only run never writes, so it does not need the directory
+	paths, err := resolvePortablePaths(exeDir, true)
+	if err != nil || !paths.ReadOnly {
+		t.Errorf("read-only: got %+v, %v", paths, err)
+	}
+}
+
+func TestWindowsDirs(t *testing.T) {
+	home := filepath.Join("C:", "Users", "player")
+	t.Setenv("APPDATA", filepath.Join("D:", "Roaming"))
+	t.Setenv("LOCALAPPDATA", filepath.Join("D:", "Local"))
+
+	config, data, cache := windowsDirs(home)
+	if config != filepath.Join("D:", "Roaming", AppDirName) ||
+		data != filepath.Join("D:", "Local", AppDirName) ||
+		cache != filepath.Join("D:", "Local", AppDirName, "cache") {
+		t.Errorf("got %s, %s, %s", config, data, cache)
+	}
+	if data == filepath.Join(executableDir(), "data") {
+		t.Error("data dir is the bundled data dir")
+	}
+
+	t.Setenv("APPDATA", "")
+	t.Setenv("LOCALAPPDATA", "")
+	_, data, _ = windowsDirs(home)
+	if data != filepath.Join(home, "AppData", "Local", AppDirName) {
+		t.Errorf("without LOCALAPPDATA: data dir %s", data)
+	}
+}
+
+func TestIsInDir(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, dir string
+		want      bool
+	}{
+		{filepath.Join(cwd, "data", CacheFileName), "data", true},
+		{filepath.Join("data", CacheFileName), filepath.Join(cwd, "data"), true},
+		{filepath.Join(cwd, "data"), "data/", true},
+		{filepath.Join(cwd, "data", "..", CacheFileName), "data", false},
+		{filepath.Join(cwd, "database", CacheFileName), "data", false},
+	}
+	for _, tt := range tests {
+		if got := isInDir(tt.path, tt.dir); got != tt.want {
+			t.Errorf("isInDir(%s, %s) = %v, want %v", tt.path, tt.dir, got, tt.want)
+		}
+	}
+}
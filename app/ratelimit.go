@@ -0,0 +1,99 @@
+package app
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxRateBuckets is how many clients are tracked before idle ones are dropped
+const maxRateBuckets = 10000
+
+// RateLimit configures the token buckets of the serve command
+type RateLimit struct {
+	// Rate is how many requests per second a client may sustain (0 = off)
+	Rate float64
+	// Burst is how many requests a client may send at once
+	Burst int
+}
+
+// DefaultRateLimit is what the serve command uses unless told otherwise
+var DefaultRateLimit = RateLimit{Rate: 10, Burst: 30}
+
+// rateLimiter keeps one token bucket per client
+type rateLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens left for one client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter, or returns nil when limit is off
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &rateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of each of the clients
+// A request is only charged when every bucket has a token; otherwise it
+// returns false and how long until all of them have one again.
+func (l *rateLimiter) allow(now time.Time, clients ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := make([]*tokenBucket, len(clients))
+	var wait time.Duration
+	for i, client := range clients {
+		bucket, ok := l.buckets[client]
+		if !ok {
+			if len(l.buckets) >= maxRateBuckets {
+				l.pruneLocked(now)
+			}
+			bucket = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+			l.buckets[client] = bucket
+		}
+		bucket.refill(l.limit, now)
+		if bucket.tokens < 1 {
+			wait = max(wait, time.Duration((1-bucket.tokens)/l.limit.Rate*float64(time.Second)))
+		}
+		buckets[i] = bucket
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return true, 0
+}
+
+// refill adds the tokens earned since the bucket was last used
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+}
+
+// pruneLocked drops the buckets that have refilled completely; callers hold l.mu
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	for client, bucket := range l.buckets {
+		bucket.refill(l.limit, now)
+		if bucket.tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
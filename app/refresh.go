@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Wails runtime events emitted by the background refresh
+const (
+	// EventCodesUpdated is emitted after fresh codes were stored in the cache
+	EventCodesUpdated = "codes:updated"
+	// EventCodesRefreshFailed is emitted when a background refresh fails
+	EventCodesRefreshFailed = "codes:refresh-failed"
+)
+
+// minRefreshInterval keeps a tiny CacheMaxAge from hammering the API
+const minRefreshInterval = time.Minute
+
+// startBackgroundRefresh refreshes the codes on a schedule derived from the
+// loader's CacheMaxAge until ctx is cancelled. An expired cache is refreshed
+// right away. Nothing is started when no API is configured.
+func (a *App) startBackgroundRefresh(ctx context.Context) {
+	interval := a.codeLoader.RefreshInterval()
+	if interval <= 0 {
+		return
+	}
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+
+	go func() {
+		if expired, err := a.cacheManager.IsCacheExpired(interval); err != nil || expired {
+			a.refreshCodes(ctx)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.refreshCodes(ctx)
+			}
+		}
+	}()
+
+	fmt.Printf("Background refresh every %s\n", interval)
+
+	// Refresh within seconds of a publish when the server streams events
+	go func() {
+		err := a.codeLoader.WatchUpdates(ctx, func(event VersionEvent) {
+			fmt.Printf("Server published version %s (%d added, %d changed, %d removed)\n",
+				event.Version, event.Added, event.Changed, event.Removed)
+			a.refreshCodes(ctx)
+		})
+		if errors.Is(err, ErrEventsUnsupported) {
+			fmt.Println("No update stream on the API, relying on scheduled refreshes")
+		}
+	}()
+}
+
+// refreshCodes fetches fresh codes and tells the frontend about the outcome
+func (a *App) refreshCodes(ctx context.Context) {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	codes, changed, err := a.codeLoader.Refresh(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Background refresh failed: %v\n", err)
+		runtime.EventsEmit(ctx, EventCodesRefreshFailed, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if !changed {
+		// 304 Not Modified: the frontend already shows the current codes
+		return
+	}
+
+	fmt.Printf("Background refresh stored %d weapon codes\n", len(codes))
+	runtime.EventsEmit(ctx, EventCodesUpdated, map[string]interface{}{
+		"code_count": len(codes),
+	})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestAPI serves codes as the weapon codes API
+func newTestAPI(t *testing.T, codes *[]WeaponCode) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != WeaponCodesPath {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{
+			Success:     true,
+			Version:     "test",
+			LastUpdated: formatCacheTime(time.Now()),
+			Data:        *codes,
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestLoaderRefresh(t *testing.T) {
+	codes := testCodes(1)
+	ts := newTestAPI(t, &codes)
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	loader := NewWeaponCodeLoaderWithConfig(cm, DataSourceConfig{
+		UseLocalCache: true,
+		APIBaseURL:    ts.URL,
+		CacheMaxAge:   time.Hour,
+	})
+
+	if interval := loader.RefreshInterval(); interval != time.Hour {
+		t.Errorf("refresh interval %s, want 1h", interval)
+	}
+	if err := cm.Save(testCodes(0), "local"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh cache does not stop an explicit refresh
+	refreshed, changed, err := loader.Refresh(context.Background())
+	if err != nil || !changed || hashWeaponCodes(refreshed) != hashWeaponCodes(codes) {
+		t.Fatalf("refresh: %d codes, err %v", len(refreshed), err)
+	}
+	cached, _, err := cm.Load()
+	if err != nil || hashWeaponCodes(cached) != hashWeaponCodes(codes) {
+		t.Errorf("refreshed codes not stored (err %v)", err)
+	}
+}
+
+func TestLoaderRefreshWithoutAPI(t *testing.T) {
+	loader := NewWeaponCodeLoader(NewCacheManager(filepath.Join(t.TempDir(), CacheFileName)))
+	if interval := loader.RefreshInterval(); interval != 0 {
+		t.Errorf("refresh interval %s without an API", interval)
+	}
+	if _, _, err := loader.Refresh(context.Background()); err == nil {
+		t.Error("refresh without an API succeeded")
+	}
+}
+
+func TestAppReadsCacheOnly(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	cm := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := cm.Save(testCodes(1), "local"); err != nil {
+		t.Fatal(err)
+	}
+	// The cache is always expired, yet the bindings must not fetch
+	loader := NewWeaponCodeLoaderWithConfig(cm, DataSourceConfig{
+		UseLocalCache: true,
+		APIBaseURL:    ts.URL,
+		CacheMaxAge:   time.Nanosecond,
+	})
+	a := NewApp(&DataLayer{Cache: cm, Loader: loader})
+
+	if codes := a.GetWeaponCodes(); len(codes) != len(testCodes(1)) {
+		t.Errorf("GetWeaponCodes: %d codes", len(codes))
+	}
+	if codes := a.GetWeaponCodesFromDaoZai(); len(codes) != 2 {
+		t.Errorf("GetWeaponCodesFromDaoZai: %d codes", len(codes))
+	}
+	if codes := a.GetWeaponCodesFromWeaponMaster(); len(codes) != 1 {
+		t.Errorf("GetWeaponCodesFromWeaponMaster: %d codes", len(codes))
+	}
+	if n := requests.Load(); n > 0 {
+		t.Errorf("bindings sent %d API requests", n)
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how APIClient retries failed requests
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries per request (1 = no retries)
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry; it doubles each time
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+	// MaxRetryAfter is the longest Retry-After the client is willing to wait;
+	// a server asking for more makes the request fail right away
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is used when a DataSourceConfig does not set one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   4,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      15 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// withDefaults fills the zero fields of p from DefaultRetryPolicy
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = DefaultRetryPolicy.MaxRetryAfter
+	}
+	return p
+}
+
+// backoff returns the jittered delay before retry number attempt (0-based)
+// It uses "full jitter": a random delay up to the exponential bound, so
+// clients that failed together do not retry together.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	bound := p.BaseDelay << attempt
+	if bound <= 0 || bound > p.MaxDelay {
+		bound = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(bound) + 1))
+}
+
+// retryableStatus reports whether a response status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or
+// as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestRetryPolicyDefaults checks that only the zero fields are defaulted
+func TestRetryPolicyDefaults(t *testing.T) {
+	if got := (RetryPolicy{}).withDefaults(); got != DefaultRetryPolicy {
+		t.Fatalf("zero policy = %+v, want %+v", got, DefaultRetryPolicy)
+	}
+	custom := RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Second, MaxRetryAfter: time.Minute}
+	if got := custom.withDefaults(); got != custom {
+		t.Fatalf("custom policy = %+v, want it unchanged", got)
+	}
+}
+
+// TestBackoff checks that the jittered delays stay within the exponential
+// bound and never exceed MaxDelay, even when the shift overflows
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for _, tc := range []struct {
+		attempt int
+		bound   time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{70, time.Second},
+	} {
+		for i := 0; i < 200; i++ {
+			if d := p.backoff(tc.attempt); d < 0 || d > tc.bound {
+				t.Fatalf("backoff(%d) = %s, want between 0 and %s", tc.attempt, d, tc.bound)
+			}
+		}
+	}
+}
+
+// TestRetryableStatus checks which statuses are retried
+func TestRetryableStatus(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusNotFound:            false,
+		http.StatusNotImplemented:      false,
+	} {
+		if got := retryableStatus(status); got != want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+// TestParseRetryAfter checks both forms of Retry-After and bad values
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 4, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+	} {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// TestSleepContext checks that a cancelled context cuts the wait short
+func TestSleepContext(t *testing.T) {
+	if err := sleepContext(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("sleep: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := sleepContext(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled sleep returned %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancelled sleep took %s", elapsed)
+	}
+}
@@ -0,0 +1,91 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"delta-tool/app"
+	"delta-tool/app/internal/apitest"
+)
+
+// TestRetry checks the retries, the Retry-After handling, the circuit
+// breaker and cancellation through the context against a failing server
+func TestRetry(t *testing.T) {
+	server := apitest.NewServer(t, apitest.Codes(1))
+	cooldown := 300 * time.Millisecond
+	loader, _ := newLoader(t, server.URL, app.DataSourceConfig{
+		Retry: app.RetryPolicy{
+			MaxAttempts:   3,
+			BaseDelay:     10 * time.Millisecond,
+			MaxDelay:      50 * time.Millisecond,
+			MaxRetryAfter: 2 * time.Second,
+		},
+		Breaker: app.BreakerConfig{Threshold: 2, Cooldown: cooldown},
+	})
+	ctx := context.Background()
+
+	// A 429 with Retry-After is waited out and retried
+	server.FailNext(1, http.StatusTooManyRequests, "1")
+	start := time.Now()
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("retry after 429: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retry after 429: retried after %s, Retry-After asked for 1s", elapsed)
+	}
+
+	// A Retry-After longer than the policy allows fails right away
+	server.FailNext(1, http.StatusServiceUnavailable, "3600")
+	before := server.Stats().Requests
+	if _, _, err := loader.Refresh(ctx); err == nil {
+		t.Fatal("long Retry-After: refresh succeeded")
+	}
+	if requests := server.Stats().Requests - before; requests != 1 {
+		t.Fatalf("long Retry-After: sent %d requests, want 1", requests)
+	}
+
+	// Running out of attempts again opens the breaker (threshold 2)
+	server.FailNext(3, http.StatusInternalServerError, "")
+	if _, _, err := loader.Refresh(ctx); err == nil {
+		t.Fatal("exhausted retries: refresh succeeded")
+	}
+	if status := loader.MirrorStatus()[0].Breaker; status.State != app.BreakerOpen {
+		t.Fatalf("exhausted retries: breaker is %s, want %s", status.State, app.BreakerOpen)
+	}
+
+	// An open breaker does not contact the server at all
+	before = server.Stats().Requests
+	if _, _, err := loader.Refresh(ctx); !errors.Is(err, app.ErrCircuitOpen) {
+		t.Fatalf("open breaker: got %v, want %v", err, app.ErrCircuitOpen)
+	}
+	if server.Stats().Requests != before {
+		t.Fatal("open breaker: server was contacted")
+	}
+
+	// After the cooldown a trial request closes it again
+	time.Sleep(cooldown)
+	if _, _, err := loader.Refresh(ctx); err != nil {
+		t.Fatalf("after cooldown: %v", err)
+	}
+	if status := loader.MirrorStatus()[0].Breaker; status.State != app.BreakerClosed {
+		t.Fatalf("after cooldown: breaker is %s, want %s", status.State, app.BreakerClosed)
+	}
+
+	// Cancelling the context interrupts the wait for Retry-After
+	server.FailNext(1, http.StatusServiceUnavailable, "1")
+	cancelCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, _, err := loader.Refresh(cancelCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Fatalf("cancelled: refresh took %s after the context expired", elapsed)
+	}
+	if status := loader.MirrorStatus()[0].Breaker; status.State != app.BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Fatal("cancelled: cancellation was counted as a failure")
+	}
+}
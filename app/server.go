@@ -0,0 +1,426 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultServeAddr is where the serve command listens by default
+	DefaultServeAddr = ":8080"
+	// HealthPath reports whether the server has codes to serve
+	HealthPath = "/healthz"
+	// maxServedVersions is how many earlier versions are kept for deltas
+	maxServedVersions = 16
+	// shutdownTimeout is how long in-flight requests get on shutdown
+	shutdownTimeout = 10 * time.Second
+)
+
+// CodeServer serves a weapon codes cache with the protocol APIClient
+// expects: full lists with ETag and Last-Modified, ?since= deltas against
+// versions served earlier, FetchOptions filtering and an event stream of
+// version changes
+type CodeServer struct {
+	cache *CacheManager
+
+	mu sync.RWMutex
+	// current is nil until the first successful Reload
+	current *servedVersion
+	// history holds the versions served before current, oldest first
+	history []*servedVersion
+	started time.Time
+
+	// admin is set by EnableAdmin
+	admin *adminState
+	// keys is set by EnableAPIKeys; nil leaves the codes open to anyone
+	keys []APIKey
+	// limiter is set by EnableRateLimit
+	limiter *rateLimiter
+	// feedDir and feed are set by EnableFeedHistory; feed holds the
+	// snapshots the feed is built from, oldest first
+	feedDir string
+	feed    []FeedSnapshot
+	// publicURL is set by SetPublicURL
+	publicURL string
+	// packDir and pack are set by EnablePack
+	packDir string
+	pack    *servedPack
+	// subscribers are the open event streams (see handleEvents)
+	subscribers map[chan VersionEvent]bool
+
+	metrics *serverMetrics
+	// accessLog is set by EnableAccessLog
+	accessLog   io.Writer
+	accessLogMu sync.Mutex
+}
+
+// servedVersion is one version of the cache as served
+type servedVersion struct {
+	version  string
+	modified time.Time
+	codes    []WeaponCode
+	// body is the encoded full response
+	body []byte
+}
+
+// HealthStatus is the body of the health endpoint
+type HealthStatus struct {
+	Status      string `json:"status"`
+	Version     string `json:"version,omitempty"`
+	LastUpdated string `json:"last_updated,omitempty"`
+	TotalCount  int    `json:"total_count"`
+	Uptime      string `json:"uptime"`
+}
+
+// NewCodeServer creates a server for cache; call Reload before serving
+func NewCodeServer(cache *CacheManager) *CodeServer {
+	return &CodeServer{cache: cache, started: time.Now(), metrics: newServerMetrics()}
+}
+
+// Reload reads the cache and publishes it when its content changed
+// The previous version is kept so clients still on it can get a delta.
+func (s *CodeServer) Reload() (changed bool, err error) {
+	defer func() { s.metrics.observeReload(changed, err) }()
+	s.reloadPack()
+
+	cache, err := s.cache.readEnvelope()
+	if err != nil {
+		return false, err
+	}
+	return s.publish(cache)
+}
+
+// LoadHistory publishes earlier versions of the codes, oldest first, so
+// clients still on one of them can get a delta. Call it before the first
+// Reload, which makes the served cache the current version.
+func (s *CodeServer) LoadHistory(versions []*WeaponCodeCache) error {
+	for _, cache := range versions {
+		if _, err := s.publish(cache); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish makes cache the current version unless it holds the same codes,
+// keeping the previous one in the history
+func (s *CodeServer) publish(cache *WeaponCodeCache) (bool, error) {
+	version := codeSetVersion(cache.WeaponCodes)
+	s.mu.RLock()
+	unchanged := s.current != nil && s.current.version == version
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	modified, err := parseCacheTime(cache.LastUpdated)
+	if err != nil {
+		modified = time.Now()
+	}
+	modified = modified.UTC().Truncate(time.Second)
+
+	// Last-Modified only has second precision, so always move it forward
+	s.mu.RLock()
+	if s.current != nil && !modified.After(s.current.modified) {
+		modified = s.current.modified.Add(time.Second)
+	}
+	s.mu.RUnlock()
+
+	next := &servedVersion{
+		version:  version,
+		modified: modified,
+		codes:    cache.WeaponCodes,
+	}
+	next.body, err = json.Marshal(APIResponse{
+		Success:     true,
+		Version:     version,
+		LastUpdated: formatCacheTime(next.modified),
+		Data:        next.codes,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal weapon codes: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := ""
+	if s.current != nil {
+		previous = s.current.version
+		s.history = append(s.history, s.current)
+		if len(s.history) > maxServedVersions {
+			s.history = s.history[len(s.history)-maxServedVersions:]
+		}
+	}
+	s.current = next
+	s.recordFeedLocked(next)
+	s.broadcastLocked(versionEvent(next, s.history, previous))
+	fmt.Printf("Serving %d weapon codes (version: %s, updated: %s)\n",
+		len(next.codes), next.version, cache.LastUpdated)
+	return true, nil
+}
+
+// codeSetVersion returns the version the server publishes codes under
+func codeSetVersion(codes []WeaponCode) string {
+	return strings.TrimPrefix(hashCodeSet(codes), hashPrefix)[:16]
+}
+
+// Handler returns the HTTP handler of the server, with gzip compression,
+// metrics and access logs
+func (s *CodeServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(WeaponCodesPath, s.guard(ScopeRead, s.handleWeaponCodes))
+	mux.Handle(EventsPath, s.guard(ScopeRead, s.handleEvents))
+	mux.Handle(FeedPath, s.guard(ScopeRead, s.handleFeed))
+	mux.Handle(DataPackPath, s.guard(ScopeRead, s.handlePack))
+	mux.Handle(HealthPath, s.guard("", s.handleHealth))
+	mux.Handle(OpenAPIPath, s.guard("", s.handleOpenAPI))
+	mux.Handle(MetricsPath, s.guard("", s.handleMetrics))
+	if s.admin != nil {
+		s.registerAdmin(mux)
+	}
+	return s.instrument(gzipHandler(mux))
+}
+
+// ListenAndServe serves on addr until ctx is cancelled, then waits for
+// in-flight requests to finish. The cache is reloaded every reloadEvery
+// (0 = never) so regenerated or refreshed codes are picked up.
+func (s *CodeServer) ListenAndServe(ctx context.Context, addr string, reloadEvery time.Duration) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(ctx, listener, reloadEvery)
+}
+
+// Serve is ListenAndServe on an existing listener
+func (s *CodeServer) Serve(ctx context.Context, listener net.Listener, reloadEvery time.Duration) error {
+	if reloadEvery > 0 {
+		go func() {
+			ticker := time.NewTicker(reloadEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if _, err := s.Reload(); err != nil {
+						fmt.Printf("Warning: Failed to reload cache: %v\n", err)
+					}
+				}
+			}
+		}()
+	}
+
+	fmt.Printf("Listening on http://%s%s\n", listener.Addr(), WeaponCodesPath)
+	return serveHTTP(ctx, listener, s.Handler())
+}
+
+// serveHTTP serves handler on listener until ctx is cancelled, then waits
+// for in-flight requests to finish
+func serveHTTP(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(listener) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Shutting down, waiting for in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down cleanly: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// snapshot returns the current version and the history under the lock
+func (s *CodeServer) snapshot() (*servedVersion, []*servedVersion) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current, s.history
+}
+
+// handleWeaponCodes serves the codes, a filtered page when the query has
+// FetchOptions, or a delta when ?since= names a version served earlier
+func (s *CodeServer) handleWeaponCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	current, history := s.snapshot()
+	if current == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, fmt.Errorf("no weapon codes loaded"))
+		return
+	}
+
+	query := r.URL.Query()
+	opts, err := ParseFetchOptions(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := APIResponse{
+		Success:     true,
+		Version:     current.version,
+		LastUpdated: formatCacheTime(current.modified),
+	}
+	if !opts.IsZero() {
+		resp.Data, resp.TotalCount, resp.NextCursor, err = FilterWeaponCodes(current.codes, opts, time.Now())
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		// A filtered page is not the representation the validators describe
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if since := query.Get(SinceParam); since != "" && since != current.version {
+		for _, old := range history {
+			if old.version == since {
+				resp.Delta = DiffCodes(old.codes, current.codes, since)
+				// A delta is a different representation of the same data,
+				// so it gets a weak ETag: a client that applied it can
+				// revalidate the full list with it, but a delta itself is
+				// never answered with 304
+				w.Header().Set("ETag", `W/"`+current.version+`"`)
+				w.Header().Set("Last-Modified", current.modified.UTC().Format(http.TimeFormat))
+				writeJSON(w, http.StatusOK, resp)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+current.version+`"`)
+	http.ServeContent(w, r, "", current.modified, bytes.NewReader(current.body))
+}
+
+// handleHealth reports the served version, or 503 before the first load
+func (s *CodeServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	current, _ := s.snapshot()
+	health := HealthStatus{
+		Status: "ok",
+		Uptime: time.Since(s.started).Round(time.Second).String(),
+	}
+	status := http.StatusOK
+	if current == nil {
+		health.Status = "no data"
+		status = http.StatusServiceUnavailable
+	} else {
+		health.Version = current.version
+		health.LastUpdated = formatCacheTime(current.modified)
+		health.TotalCount = len(current.codes)
+	}
+	writeJSON(w, status, health)
+}
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeAPIError sends an unsuccessful APIResponse carrying err
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, APIResponse{Success: false, Message: err.Error()})
+}
+
+// gzipHandler compresses responses for clients that accept gzip
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// Event streams are flushed event by event, compression would hold them back
+		if !acceptsGzip(r) || r.URL.Path == EventsPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Byte ranges would refer to the uncompressed body
+		r.Header.Del("Range")
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body of responses that have one
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusNotModified && status != http.StatusNoContent &&
+		w.Header().Get("Content-Encoding") == "" {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.Header().Del("Accept-Ranges")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+// Close flushes the compressed body
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCodeServer saves codes to a throwaway cache and serves it
+func newTestCodeServer(t *testing.T, codes []WeaponCode) (*CodeServer, *CacheManager) {
+	t.Helper()
+	served := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := served.Save(codes, "local"); err != nil {
+		t.Fatal(err)
+	}
+	server := NewCodeServer(served)
+	if _, err := server.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	return server, served
+}
+
+// newTestLoader returns a loader with an empty cache fetching from baseURL
+func newTestLoader(t *testing.T, baseURL string) (*WeaponCodeLoader, string) {
+	t.Helper()
+	dir := t.TempDir()
+	loader := NewWeaponCodeLoaderWithConfig(NewCacheManager(filepath.Join(dir, CompressedCacheFileName)), DataSourceConfig{
+		UseLocalCache:  true,
+		APIBaseURL:     baseURL,
+		CacheMaxAge:    time.Hour,
+		Retry:          RetryPolicy{MaxAttempts: 1},
+		QuarantinePath: filepath.Join(dir, QuarantineFileName),
+	})
+	return loader, dir
+}
+
+// TestServe runs Serve on a listener and refreshes a second cache from it,
+// then checks deltas, filters, compression, health and a clean shutdown
+func TestServe(t *testing.T) {
+	server, served := newTestCodeServer(t, testCodes(1))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener, 0) }()
+	baseURL := "http://" + listener.Addr().String()
+
+	loader, _ := newTestLoader(t, baseURL)
+	cm := loader.cacheManager
+	refresh := func(step string, wantChanged bool) {
+		t.Helper()
+		_, changed, err := loader.Refresh(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if changed != wantChanged {
+			t.Fatalf("%s: changed = %v, want %v", step, changed, wantChanged)
+		}
+	}
+	refresh("first fetch", true)
+	refresh("unchanged", false)
+
+	// A new version is picked up on reload and sent as a delta
+	if err := served.Save(testCodes(2), "local"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := server.Reload(); err != nil || !changed {
+		t.Fatalf("reload: changed = %v, err = %v", changed, err)
+	}
+	prev := cm.EndpointValidators(WeaponCodesPath)
+	client := NewAPIClient(baseURL)
+	resp, _, err := client.FetchWeaponCodesConditional(ctx, EndpointMeta{DataVersion: prev.DataVersion})
+	if err != nil {
+		t.Fatalf("delta: %v", err)
+	}
+	if resp.Delta == nil {
+		t.Fatal("delta: server sent the full list for a version it served")
+	}
+	refresh("changed", true)
+	codes, _, err := cm.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashCodeSet(codes) != hashCodeSet(testCodes(2)) {
+		t.Fatal("changed: client cache does not match the served codes")
+	}
+
+	// Filters are answered by the server
+	page, err := client.FetchWeaponCodesWithOptions(ctx, FetchOptions{Source: "刀仔", Limit: 1})
+	if err != nil {
+		t.Fatalf("filters: %v", err)
+	}
+	if len(page.Data) != 1 || page.TotalCount != 2 || page.NextCursor == "" {
+		t.Fatalf("filters: got %d entries of %d, cursor %q", len(page.Data), page.TotalCount, page.NextCursor)
+	}
+
+	// Compression and health
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+WeaponCodesPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	gzResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	gzResp.Body.Close()
+	if encoding := gzResp.Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("gzip: Content-Encoding is %q", encoding)
+	}
+
+	healthResp, err := http.Get(baseURL + HealthPath)
+	if err != nil {
+		t.Fatalf("health: %v", err)
+	}
+	var health HealthStatus
+	err = json.NewDecoder(healthResp.Body).Decode(&health)
+	healthResp.Body.Close()
+	if err != nil || healthResp.StatusCode != http.StatusOK || health.TotalCount != len(codes) {
+		t.Fatalf("health: status %d, %+v, err = %v", healthResp.StatusCode, health, err)
+	}
+
+	// Cancelling the context shuts the server down cleanly
+	stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("shutdown: %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("shutdown: server did not stop")
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	current := NewCacheManager(filepath.Join(t.TempDir(), CompressedCacheFileName))
+	if err := current.Save(testCodes(3), "local"); err != nil {
+		t.Fatal(err)
+	}
+	server := NewCodeServer(current)
+	var history []*WeaponCodeCache
+	for _, version := range []int{1, 2} {
+		history = append(history, &WeaponCodeCache{LastUpdated: formatCacheTime(time.Now()), WeaponCodes: testCodes(version)})
+	}
+	if err := server.LoadHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	served, earlier := server.snapshot()
+	if served.version != codeSetVersion(testCodes(3)) || len(earlier) != 2 {
+		t.Fatalf("serving %s with %d earlier versions", served.version, len(earlier))
+	}
+
+	// A client on the oldest version gets a delta
+	since := codeSetVersion(testCodes(1))
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, WeaponCodesPath+"?"+SinceParam+"="+since, nil))
+	var resp APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Delta == nil || resp.Delta.BaseVersion != since {
+		t.Errorf("no delta from the oldest version: %s", rec.Body.String())
+	}
+	deltaETag := rec.Header().Get("ETag")
+	if deltaETag != `W/"`+served.version+`"` {
+		t.Errorf("delta served with the ETag %s", deltaETag)
+	}
+
+	// A delta is never answered with 304, even for the current version
+	req := httptest.NewRequest(http.MethodGet, WeaponCodesPath+"?"+SinceParam+"="+since, nil)
+	req.Header.Set("If-None-Match", `"`+served.version+`"`)
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("conditional delta request got %d", rec.Code)
+	}
+
+	// but its ETag revalidates the full list
+	req = httptest.NewRequest(http.MethodGet, WeaponCodesPath, nil)
+	req.Header.Set("If-None-Match", deltaETag)
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("full list revalidated with the delta ETag got %d", rec.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// legacyTimeLayout is the zone-less timestamp format written by legacyCacheVersion
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+// excelParserVersion is bumped whenever the Excel parsers change their output
+// 2: stable IDs derived from the entry instead of row counters
+const excelParserVersion = "2"
+
+// SourceMeta describes one data source inside the cache envelope
+type SourceMeta struct {
+	// Name is the source identifier used in WeaponCode.Source
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Homepage is the creator's page where the codes are published
+	Homepage string `json:"homepage,omitempty"`
+	// FileHash is the SHA-256 hash of the source workbook, if parsed from Excel
+	FileHash string `json:"file_hash,omitempty"`
+	// RowCount is the number of entries from this source
+	RowCount int `json:"row_count"`
+	// ContentHash is the SHA-256 hash of this source's entries
+	ContentHash string `json:"content_hash,omitempty"`
+	// FetchedAt is when the entries were read from the source (RFC 3339)
+	FetchedAt string `json:"fetched_at"`
+	// DataAsOf is when the source itself last changed, if known (RFC 3339)
+	DataAsOf string `json:"data_as_of,omitempty"`
+	// ParsedAt is when the source workbook was last parsed (RFC 3339)
+	ParsedAt      string `json:"parsed_at,omitempty"`
+	ParserVersion string `json:"parser_version,omitempty"`
+}
+
+// sourceDefinition describes a creator whose codes we collect
+type sourceDefinition struct {
+	Name        string
+	DisplayName string
+	Homepage    string
+	// FileName is the workbook in data/ the codes are parsed from
+	FileName string
+	// parse extracts the weapon codes from the workbook
+	parse func(f *excelize.File) ([]WeaponCode, error)
+}
+
+// knownSources lists every supported data source
+var knownSources = []sourceDefinition{
+	{
+		Name:        "刀仔",
+		DisplayName: "刀仔（三角洲枪匠之王）",
+		Homepage:    "https://v.douyin.com/xsjMEZDNbbY/",
+		FileName:    daoZaiFileName,
+		parse:       parse刀仔File,
+	},
+	{
+		Name:        "武器大师",
+		DisplayName: "武器大师地板",
+		FileName:    weaponMasterFileName,
+		parse:       parseWeaponMasterFile,
+	},
+}
+
+// parseWorkbook extracts the codes of the source and gives them stable IDs
+func (def sourceDefinition) parseWorkbook(f *excelize.File) ([]WeaponCode, error) {
+	codes, err := def.parse(f)
+	if err != nil {
+		return nil, err
+	}
+	assignStableIDs(codes)
+	return codes, nil
+}
+
+// findSourceDefinition returns the definition of a known source
+func findSourceDefinition(name string) (sourceDefinition, bool) {
+	for _, def := range knownSources {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return sourceDefinition{}, false
+}
+
+// formatCacheTime formats a timestamp for the cache envelope
+func formatCacheTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// parseCacheTime parses an envelope timestamp
+// RFC 3339 is expected; the legacy zone-less format is read as local time.
+func parseCacheTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(legacyTimeLayout, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// buildSourceMeta returns one SourceMeta per source present in codes
+// Entries in known are kept as given; other sources are marked as fetched at now.
+// Row counts and content hashes are always computed from codes, and the
+// display name and homepage default to those of the known source.
+func buildSourceMeta(codes []WeaponCode, known []SourceMeta, now time.Time) []SourceMeta {
+	bySource := make(map[string]SourceMeta, len(known))
+	for _, meta := range known {
+		bySource[meta.Name] = meta
+	}
+
+	var sources []SourceMeta
+	seen := make(map[string]bool)
+	contentHashes := hashSources(codes)
+	for _, code := range codes {
+		if seen[code.Source] {
+			continue
+		}
+		seen[code.Source] = true
+
+		meta, ok := bySource[code.Source]
+		if !ok {
+			meta = SourceMeta{Name: code.Source}
+		}
+		if meta.FetchedAt == "" {
+			meta.FetchedAt = formatCacheTime(now)
+		}
+		if def, ok := findSourceDefinition(meta.Name); ok {
+			if meta.DisplayName == "" {
+				meta.DisplayName = def.DisplayName
+			}
+			if meta.Homepage == "" {
+				meta.Homepage = def.Homepage
+			}
+		}
+		meta.RowCount = len(filterBySource(codes, meta.Name))
+		meta.ContentHash = contentHashes[meta.Name]
+		sources = append(sources, meta)
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources
+}
+
+// SourceTable returns the per-source metadata of the cache
+// Caches written before version 1.2.0 have no table; for those the rows are
+// derived from the entries and carry no fetch times or hashes.
+func (cache *WeaponCodeCache) SourceTable() []SourceMeta {
+	if len(cache.Sources) > 0 {
+		return cache.Sources
+	}
+
+	sources := buildSourceMeta(cache.WeaponCodes, nil, time.Time{})
+	for i := range sources {
+		sources[i].FetchedAt = ""
+		sources[i].ContentHash = ""
+	}
+	return sources
+}
+
+// oldestFetch returns the earliest time any part of the cache was fetched
+// It uses the per-source fetch times and falls back to LastUpdated.
+func (cache *WeaponCodeCache) oldestFetch() (time.Time, error) {
+	var oldest time.Time
+	for _, meta := range cache.Sources {
+		fetched, err := parseCacheTime(meta.FetchedAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("source %s: %w", meta.Name, err)
+		}
+		if oldest.IsZero() || fetched.Before(oldest) {
+			oldest = fetched
+		}
+	}
+	if !oldest.IsZero() {
+		return oldest, nil
+	}
+
+	return parseCacheTime(cache.LastUpdated)
+}
+
+// freshAsOf returns the time up to which the cache is known to be current:
+// the oldest fetch, or the last "not modified" check if that is later.
+func (cache *WeaponCodeCache) freshAsOf() (time.Time, error) {
+	fresh, err := cache.oldestFetch()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if checked, err := parseCacheTime(cache.CheckedAt); err == nil && checked.After(fresh) {
+		fresh = checked
+	}
+	return fresh, nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildSourceMeta(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	known := []SourceMeta{{Name: "武器大师", FetchedAt: "2025-12-01T00:00:00Z", FileHash: "sha256:abc", RowCount: 99}}
+	codes := append(testCodes(1), WeaponCode{ID: "x", Mode: "烽火地带", Name: "MP7", Code: testCode(9), Source: "路人"})
+
+	sources := buildSourceMeta(codes, known, now)
+	if len(sources) != 3 {
+		t.Fatalf("got %d sources", len(sources))
+	}
+	bySource := make(map[string]SourceMeta)
+	for _, meta := range sources {
+		bySource[meta.Name] = meta
+	}
+
+	daoZai := bySource["刀仔"]
+	if daoZai.FetchedAt != formatCacheTime(now) || daoZai.RowCount != 2 || daoZai.DisplayName == "" || daoZai.Homepage == "" {
+		t.Errorf("刀仔: %+v", daoZai)
+	}
+	// Given metadata is kept, but counts and hashes follow the entries
+	master := bySource["武器大师"]
+	if master.FetchedAt != known[0].FetchedAt || master.FileHash != known[0].FileHash || master.RowCount != 1 {
+		t.Errorf("武器大师: %+v", master)
+	}
+	if master.ContentHash != hashWeaponCodes(filterBySource(codes, "武器大师")) {
+		t.Errorf("武器大师: content hash %s", master.ContentHash)
+	}
+	if other := bySource["路人"]; other.DisplayName != "" || other.RowCount != 1 {
+		t.Errorf("unknown source: %+v", other)
+	}
+}
+
+func TestSourceTable(t *testing.T) {
+	cache := &WeaponCodeCache{WeaponCodes: testCodes(1)}
+	// Caches before the source table only know which sources there are
+	table := cache.SourceTable()
+	if len(table) != 2 || table[0].FetchedAt != "" || table[0].ContentHash != "" || table[0].RowCount == 0 {
+		t.Errorf("derived table: %+v", table)
+	}
+
+	cache.Sources = buildSourceMeta(cache.WeaponCodes, nil, time.Now())
+	if table := cache.SourceTable(); len(table) != 2 || table[0].FetchedAt == "" {
+		t.Errorf("stored table: %+v", table)
+	}
+}
+
+func TestParseCacheTime(t *testing.T) {
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got, err := parseCacheTime(formatCacheTime(want)); err != nil || !got.Equal(want) {
+		t.Errorf("RFC 3339: got %v, %v", got, err)
+	}
+
+	// Version 1.0.0 wrote local time without a zone
+	local := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	if got, err := parseCacheTime(local.Format(legacyTimeLayout)); err != nil || !got.Equal(local) {
+		t.Errorf("legacy: got %v, %v", got, err)
+	}
+
+	if _, err := parseCacheTime("yesterday"); err == nil {
+		t.Error("invalid timestamp accepted")
+	}
+}
+
+func TestOldestFetch(t *testing.T) {
+	older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	cache := &WeaponCodeCache{
+		LastUpdated: formatCacheTime(newer),
+		Sources: []SourceMeta{
+			{Name: "刀仔", FetchedAt: formatCacheTime(newer)},
+			{Name: "武器大师", FetchedAt: formatCacheTime(older)},
+		},
+	}
+	if got, err := cache.oldestFetch(); err != nil || !got.Equal(older) {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	cache.Sources = nil
+	if got, err := cache.oldestFetch(); err != nil || !got.Equal(newer) {
+		t.Errorf("without sources: got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuarantineFileName is where the last rejected dataset is kept for inspection
+const QuarantineFileName = "weapon_codes.rejected.json"
+
+// ErrDatasetRejected is returned when fetched codes fail validation and
+// the cache is left untouched
+var ErrDatasetRejected = errors.New("dataset rejected")
+
+// validModes lists the game modes a weapon code can belong to
+var validModes = map[string]bool{
+	"烽火地带": true,
+	"全面战场": true,
+}
+
+// codePattern matches a weapon code: 21 characters, optionally prefixed
+// with the weapon and mode ("M14射手步枪-烽火地带-6IMJI6004E93FJHAQGRLM")
+var codePattern = regexp.MustCompile(`(^|-)[0-9A-Z]{21}$`)
+
+// maxReportedProblems limits how many entry problems are listed in an error
+const maxReportedProblems = 5
+
+// ValidationConfig controls the checks run before fetched codes replace the cache
+type ValidationConfig struct {
+	// MaxDropPercent is the largest allowed drop in the number of entries
+	// compared to the cache (0 = 20%, 100 = no limit)
+	MaxDropPercent float64
+}
+
+// maxDropPercent returns the configured drop limit or its default
+func (c ValidationConfig) maxDropPercent() float64 {
+	if c.MaxDropPercent <= 0 {
+		return 20
+	}
+	return c.MaxDropPercent
+}
+
+// validateDataset checks fetched codes before they replace current ones
+// It runs schema and code checks on every entry, and guards against the
+// entry count dropping too far or a source vanishing. current may be empty
+// when there is no cache yet. No problems means the dataset is accepted.
+func validateDataset(current, fetched []WeaponCode, config ValidationConfig) []string {
+	var problems []string
+	if len(fetched) == 0 {
+		return []string{"dataset is empty"}
+	}
+
+	// Schema and code checks
+	var entryProblems []string
+	seen := make(map[string]bool, len(fetched))
+	for i, code := range fetched {
+		var missing []string
+		for _, field := range []struct{ name, value string }{
+			{"id", code.ID}, {"name", code.Name}, {"code", code.Code}, {"source", code.Source},
+		} {
+			if strings.TrimSpace(field.value) == "" {
+				missing = append(missing, field.name)
+			}
+		}
+		if len(missing) > 0 {
+			entryProblems = append(entryProblems, fmt.Sprintf("entry %d: missing %s", i, strings.Join(missing, ", ")))
+			continue
+		}
+		if seen[code.ID] {
+			entryProblems = append(entryProblems, fmt.Sprintf("entry %d: duplicate id %q", i, code.ID))
+		}
+		seen[code.ID] = true
+		if !validModes[code.Mode] {
+			entryProblems = append(entryProblems, fmt.Sprintf("entry %s: unknown mode %q", code.ID, code.Mode))
+		}
+		if !codePattern.MatchString(code.Code) {
+			entryProblems = append(entryProblems, fmt.Sprintf("entry %s: invalid code %q", code.ID, code.Code))
+		}
+	}
+	if len(entryProblems) > maxReportedProblems {
+		more := len(entryProblems) - maxReportedProblems
+		entryProblems = append(entryProblems[:maxReportedProblems], fmt.Sprintf("and %d more invalid entries", more))
+	}
+	problems = append(problems, entryProblems...)
+
+	if len(current) == 0 {
+		return problems
+	}
+
+	// Entry count guard
+	limit := config.maxDropPercent()
+	if drop := 100 * float64(len(current)-len(fetched)) / float64(len(current)); drop > limit {
+		problems = append(problems, fmt.Sprintf("entry count dropped by %.0f%% (%d -> %d), limit is %.0f%%",
+			drop, len(current), len(fetched), limit))
+	}
+
+	// Vanished sources
+	fetchedSources := make(map[string]bool)
+	for _, code := range fetched {
+		fetchedSources[code.Source] = true
+	}
+	var vanished []string
+	reported := make(map[string]bool)
+	for _, code := range current {
+		if !fetchedSources[code.Source] && !reported[code.Source] {
+			reported[code.Source] = true
+			vanished = append(vanished, code.Source)
+		}
+	}
+	sort.Strings(vanished)
+	for _, source := range vanished {
+		problems = append(problems, fmt.Sprintf("source %s vanished", source))
+	}
+
+	return problems
+}
+
+// quarantinedDataset is the content of the quarantine file
+type quarantinedDataset struct {
+	RejectedAt string       `json:"rejected_at"`
+	Mirror     string       `json:"mirror,omitempty"`
+	Problems   []string     `json:"problems"`
+	Response   *APIResponse `json:"response"`
+}
+
+// quarantineDataset writes a rejected API response to path for inspection
+func quarantineDataset(path, mirror string, problems []string, apiResp *APIResponse) error {
+	data, err := json.MarshalIndent(quarantinedDataset{
+		RejectedAt: formatCacheTime(time.Now()),
+		Mirror:     mirror,
+		Problems:   problems,
+		Response:   apiResp,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rejected dataset: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quarantine file: %w", err)
+	}
+	return nil
+}
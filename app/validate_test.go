@@ -0,0 +1,61 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDataset(t *testing.T) {
+	code := func(id, source string) WeaponCode {
+		return WeaponCode{ID: id, Name: "M14", Mode: "烽火地带", Code: "6IMJI6004E93FJHAQGRLM", Source: source}
+	}
+	current := []WeaponCode{code("a", "刀仔"), code("b", "刀仔"), code("c", "武器大师"), code("d", "武器大师"), code("e", "武器大师")}
+
+	tests := []struct {
+		name    string
+		fetched []WeaponCode
+		ok      bool
+	}{
+		{"same", current, true},
+		{"empty", nil, false},
+		{"duplicate id", []WeaponCode{code("a", "刀仔"), code("a", "武器大师"), code("c", "武器大师"), code("d", "武器大师"), code("e", "武器大师")}, false},
+		{"invalid code", append([]WeaponCode{{ID: "x", Name: "M14", Mode: "烽火地带", Code: "short", Source: "刀仔"}}, current[1:]...), false},
+		{"unknown mode", append([]WeaponCode{{ID: "x", Name: "M14", Mode: "?", Code: "6IMJI6004E93FJHAQGRLM", Source: "刀仔"}}, current[1:]...), false},
+		{"too many dropped", current[:3], false},
+		{"source vanished", []WeaponCode{code("c", "武器大师"), code("d", "武器大师"), code("e", "武器大师"), code("f", "武器大师"), code("g", "武器大师")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateDataset(current, tt.fetched, ValidationConfig{})
+			if ok := len(problems) == 0; ok != tt.ok {
+				t.Errorf("accepted = %v, want %v (problems: %v)", ok, tt.ok, problems)
+			}
+		})
+	}
+}
+
+// TestValidateBundledData runs the checks every fetched dataset goes
+// through on the data shipped in data/, so a legitimate code the format
+// check does not know cannot quarantine every refresh
+func TestValidateBundledData(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("..", "data", CacheFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := parseEnvelope(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range cache.WeaponCodes {
+		if !codePattern.MatchString(code.Code) {
+			t.Errorf("%s %s (%s): code %q does not match the format check", code.Name, code.Build, code.Source, code.Code)
+		}
+	}
+	if problems := validateDataset(nil, cache.WeaponCodes, ValidationConfig{}); len(problems) > 0 {
+		t.Errorf("first fetch of the bundled data rejected: %v", problems)
+	}
+	if problems := validateDataset(cache.WeaponCodes, cache.WeaponCodes, ValidationConfig{}); len(problems) > 0 {
+		t.Errorf("refetch of the bundled data rejected: %v", problems)
+	}
+}
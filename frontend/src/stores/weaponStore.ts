@@ -110,6 +110,23 @@ export const useWeaponStore = defineStore('weapons', () => {
     }
   }
 
+  // Reload whenever the backend refreshes the codes in the background
+  let listening = false
+  const listenForUpdates = () => {
+    const runtime = (window as any).runtime
+    if (listening || !runtime || !runtime.EventsOn) {
+      return
+    }
+    listening = true
+
+    runtime.EventsOn('codes:updated', () => {
+      loadCodes()
+    })
+    runtime.EventsOn('codes:refresh-failed', (info: { error: string }) => {
+      console.warn('Background refresh failed:', info?.error)
+    })
+  }
+
   // Get all unique modes
   const uniqueModes = computed(() => {
     const modes = new Set<string>()
@@ -212,6 +229,7 @@ export const useWeaponStore = defineStore('weapons', () => {
     filteredCodes,
     groupedCodes,
     uniqueModes,
-    loadCodes
+    loadCodes,
+    listenForUpdates
   }
 })